@@ -0,0 +1,159 @@
+// See license file for copyright and license details.
+
+// This file implements a small local HTTP server which receives the
+// additionalData POSTed by first-screen applications to the additionalDataUrl
+// passed at launch time (DIAL 2.1).
+
+package dial
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	callbackPath        = "/additionalData/"
+	callbackMaxBodySize = 64 * 1024
+	callbackTimeout     = 2 * time.Minute // unused additionalDataUrls are released after this long.
+)
+
+var errNoCallbackHost = errors.New("unable to determine callback host")
+
+// CallbackServer is a local HTTP server which allocates an additionalDataUrl
+// for each launch and delivers the additionalData POSTed by the launched
+// application to LaunchResult.AdditionalData.
+type CallbackServer struct {
+	ln  net.Listener
+	srv *http.Server
+
+	mu      sync.Mutex
+	pending map[string]*callback // by id.
+}
+
+// callback is an allocated additionalDataUrl waiting for data.
+type callback struct {
+	ch    chan string
+	timer *time.Timer // releases the callback after callbackTimeout.
+}
+
+// NewCallbackServer starts a CallbackServer listening on localAddr. If
+// localAddr is empty, it listens on all interfaces on a random port.
+func NewCallbackServer(localAddr string) (*CallbackServer, error) {
+	if localAddr == "" {
+		localAddr = ":0"
+	}
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &CallbackServer{ln: ln, pending: make(map[string]*callback)}
+	s.srv = &http.Server{Handler: s, ReadTimeout: httpTimeout, WriteTimeout: httpTimeout}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("CallbackServer: %s", err)
+		}
+	}()
+	log.Printf("CallbackServer listening on %s", ln.Addr())
+	return s, nil
+}
+
+// Close shuts down the CallbackServer, pending AdditionalData channels won't
+// receive anything.
+func (s *CallbackServer) Close() error {
+	return s.srv.Close()
+}
+
+// allocate returns a new additionalDataUrl reachable by the device at
+// deviceUrl, the channel that will receive the data POSTed to it and the
+// function that releases it. It's released anyway when the first data is
+// received or after callbackTimeout.
+func (s *CallbackServer) allocate(deviceUrl string) (string, chan string, func(), error) {
+	host, err := s.hostFor(deviceUrl)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, nil, err
+	}
+	id := hex.EncodeToString(b)
+	cb := &callback{ch: make(chan string, 1)}
+	release := func() { s.take(id) }
+	s.mu.Lock()
+	s.pending[id] = cb
+	cb.timer = time.AfterFunc(callbackTimeout, release)
+	s.mu.Unlock()
+	return "http://" + host + callbackPath + id, cb.ch, release, nil
+}
+
+// take removes the callback id from the pending ones and returns it, or nil
+// if it was already released.
+func (s *CallbackServer) take(id string) *callback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cb, ok := s.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(s.pending, id)
+	cb.timer.Stop()
+	return cb
+}
+
+// hostFor returns host:port of the CallbackServer as seen from the device at
+// deviceUrl, i.e. using the local ip of the route to the device.
+func (s *CallbackServer) hostFor(deviceUrl string) (string, error) {
+	_, port, err := net.SplitHostPort(s.ln.Addr().String())
+	if err != nil {
+		return "", err
+	}
+	if ta, ok := s.ln.Addr().(*net.TCPAddr); ok && !ta.IP.IsUnspecified() {
+		return net.JoinHostPort(ta.IP.String(), port), nil
+	}
+	u, err := url.Parse(deviceUrl)
+	if err != nil {
+		return "", err
+	}
+	// dialing udp doesn't send anything, it only selects the route.
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), "9"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	ua, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", errNoCallbackHost
+	}
+	return net.JoinHostPort(ua.IP.String(), port), nil // zone is meaningless for the device.
+}
+
+func (s *CallbackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutPrefix(r.URL.Path, callbackPath)
+	if !ok || r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, callbackMaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the app may POST more than once, only the first data is kept.
+	cb := s.take(id)
+	if cb == nil {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("received additionalData from %s", r.RemoteAddr)
+	cb.ch <- string(data) // buffered, never blocks.
+	w.WriteHeader(http.StatusOK)
+}
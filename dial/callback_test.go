@@ -0,0 +1,103 @@
+// See license file for copyright and license details.
+
+package dial
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaunchWithCallback(t *testing.T) {
+	additionalData := "<screenId>screen123</screenId>"
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/apps/YouTube" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = r.URL.Query()
+		body, _ := io.ReadAll(r.Body)
+		params, err := url.ParseQuery(string(body))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the first-screen app posts its additionalData asynchronously.
+		go http.Post(params.Get("additionalDataUrl"), "text/xml", strings.NewReader(additionalData))
+		w.Header().Set("Location", "http://"+r.Host+"/apps/YouTube/run")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	cb, err := NewCallbackServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cb.Close()
+
+	dev := &Device{ApplicationUrl: srv.URL + "/apps"}
+	if err := dev.SetLocalAddr(""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	opts := LaunchOptions{FriendlyName: "ytcast@host", ClientDialVer: ClientDialVer, Callback: cb}
+	res, err := dev.LaunchWithOptions("YouTube", "https://www.youtube.com", "v=foo", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := srv.URL + "/apps/YouTube/run"; res.InstanceUrl != want {
+		t.Fatalf("res.InstanceUrl: want %q got %q", want, res.InstanceUrl)
+	}
+	if got := query.Get("friendlyName"); got != opts.FriendlyName {
+		t.Fatalf("friendlyName: want %q got %q", opts.FriendlyName, got)
+	}
+	if got := query.Get("clientDialVer"); got != opts.ClientDialVer {
+		t.Fatalf("clientDialVer: want %q got %q", opts.ClientDialVer, got)
+	}
+	select {
+	case data := <-res.AdditionalData:
+		if data != additionalData {
+			t.Fatalf("res.AdditionalData: want %q got %q", additionalData, data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("res.AdditionalData: timeout")
+	}
+	cb.mu.Lock()
+	pending := len(cb.pending)
+	cb.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("pending: want 0 got %d", pending)
+	}
+}
+
+func TestCallbackRelease(t *testing.T) {
+	cb, err := NewCallbackServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cb.Close()
+
+	dataUrl, _, release, err := cb.allocate("http://127.0.0.1:8008/apps")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	release()
+	cb.mu.Lock()
+	pending := len(cb.pending)
+	cb.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("pending: want 0 got %d", pending)
+	}
+	resp, err := http.Post(dataUrl, "text/xml", strings.NewReader("<screenId>screen123</screenId>"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status: want %d got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
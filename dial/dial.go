@@ -5,55 +5,191 @@
 package dial
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+
+	"github.com/MarcoLucidi01/ytcast/ssdp"
 )
 
 const (
-	dialSearchTarget = "urn:dial-multiscreen-org:service:dial:1"
+	dialSearchTarget = ssdp.Dial
+
+	// MSearchMinTimeout and MSearchMaxTimeout bound the discovery timeout.
+	MSearchMinTimeout = ssdp.MinTimeout
+	MSearchMaxTimeout = ssdp.MaxTimeout
+
+	// ClientDialVer is the DIAL version implemented by this package.
+	ClientDialVer = "2.1"
 
 	httpTimeout = 5 * time.Second
 
 	contentType = "text/plain; charset=utf-8"
 
-	wakeupBroadcastAddr = "255.255.255.255:9"
-	wakeupMinTimeout    = 10 * time.Second
-	wakeupMaxTimeout    = 2 * time.Minute
+	wakeupMinTimeout = 10 * time.Second
+	wakeupMaxTimeout = 2 * time.Minute
 )
 
+// WellKnownApps are some application names registered in the DIAL Registry.
+// See http://www.dial-multiscreen.org/dial-registry/namespace-database
+var WellKnownApps = []string{
+	"AmazonInstantVideo",
+	"BBCiPlayer",
+	"Hulu",
+	"Netflix",
+	"Pandora",
+	"Tubi",
+	"YouTube",
+	"YouTubeKids",
+	"YouTubeTV",
+	"com.spotify.Spotify.TVv2",
+}
+
 var (
 	wakeupParseRe = regexp.MustCompile(`MAC=(.+);Timeout=(\d+)`)
 
-	errNoAppUrl = errors.New("missing Application-URL header")
-	errNoMac    = errors.New("missing device MAC address")
-	errNoWakeup = errors.New("unable to wakeup device")
+	errBadHttpStatus = errors.New("bad HTTP response status")
+	errNoAppUrl      = errors.New("missing Application-URL header")
+	errNoMac         = errors.New("missing device MAC address")
+	errNoWakeup      = errors.New("unable to wakeup device")
+	errNoInstanceUrl = errors.New("missing application instance url")
+	errNoInstallUrl  = errors.New("missing application install url")
+	errNoUDN         = errors.New("missing UDN in device description")
+
+	// ErrNotRunning is returned by Stop() if the application is not running.
+	ErrNotRunning = errors.New("application is not running")
+	// ErrStopNotAllowed is returned by Stop() if the application can't be
+	// stopped through DIAL.
+	ErrStopNotAllowed = errors.New("application stop not allowed")
+
+	// the following errors are matched (with errors.Is()) by a StatusError
+	// with the corresponding status code, which has this meaning in DIAL.
+	ErrAppNotFound     = errors.New("application not found")             // 404: the application is not registered on the Device.
+	ErrOriginRejected  = errors.New("origin rejected")                   // 403: the Device doesn't accept requests from the Origin.
+	ErrPayloadTooLarge = errors.New("payload too large")                 // 413: the launch payload exceeds the Device limit.
+	ErrNotImplemented  = errors.New("not implemented")                   // 501: the Device doesn't support the operation.
+	ErrAppUnavailable  = errors.New("application can't be launched now") // 503: e.g. the Device is busy, retry later.
 )
 
+// statusErrors maps HTTP status codes to their DIAL meaning.
+var statusErrors = map[int]error{
+	http.StatusNotFound:              ErrAppNotFound,
+	http.StatusForbidden:             ErrOriginRejected,
+	http.StatusRequestEntityTooLarge: ErrPayloadTooLarge,
+	http.StatusNotImplemented:        ErrNotImplemented,
+	http.StatusServiceUnavailable:    ErrAppUnavailable,
+}
+
+const statusErrorMaxBody = 128
+
+// StatusError is returned when a DIAL server responds with a status code other
+// than 2xx. It matches with errors.Is() the error with the DIAL meaning of the
+// status code (e.g. ErrAppNotFound for 404), if any.
+type StatusError struct {
+	Method string // method of the request.
+	Url    string // url of the request.
+	Status string // status of the response, e.g. "404 Not Found".
+	Code   int    // status code of the response, e.g. 404.
+	Body   string // excerpt of the response body (if any), on a single line.
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Method, e.Url, e.Status)
+	if err := statusErrors[e.Code]; err != nil {
+		msg += ": " + err.Error()
+	} else {
+		msg += ": " + errBadHttpStatus.Error()
+	}
+	if e.Body != "" {
+		msg += fmt.Sprintf(" (%q)", e.Body)
+	}
+	return msg
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == errBadHttpStatus || (target != nil && target == statusErrors[e.Code])
+}
+
+// newStatusError returns a StatusError for resp to the request method url.
+func newStatusError(method, url string, resp *http.Response, body []byte) *StatusError {
+	excerpt := strings.Join(strings.Fields(string(body)), " ")
+	if len(excerpt) > statusErrorMaxBody {
+		excerpt = strings.ToValidUTF8(excerpt[:statusErrorMaxBody], "") + "..."
+	}
+	return &StatusError{Method: method, Url: url, Status: resp.Status, Code: resp.StatusCode, Body: excerpt}
+}
+
 // Device is a DIAL server device discovered on the network.
 type Device struct {
 	localAddr  string       // localAddr is the local address the Device instance must use for network operations.
 	httpClient *http.Client // httpClient is an http.Client setup to use localAddr.
 
-	UniqueServiceName string // UniqueServiceName from the ssdpService.
-	Location          string // Location from the ssdpService.
+	UniqueServiceName string // UniqueServiceName from the SSDP M-SEARCH response.
+	Location          string // Location from the SSDP M-SEARCH response.
 	ApplicationUrl    string // base DIAL REST service url.
 	FriendlyName      string // UPnP friendlyName field of the device description.
-	Wakeup            Wakeup // WAKEUP header values from the ssdpService (if available).
+	Wakeup            Wakeup // WAKEUP header values from the SSDP M-SEARCH response (if available).
+	DiscoveryAddr     string // local address that reached the Device during discovery.
+	SearchHost        string // host (or LOCATION) the Device was discovered at with DiscoverHosts() (if any).
+
+	// the following fields come from the SSDP M-SEARCH response (or NOTIFY
+	// message) and are used to tell whether the Device is still there and
+	// whether its description may have changed.
+	MaxAge   time.Duration // CACHE-CONTROL max-age: how long the Device announcement is valid (0 if unknown).
+	BootId   string        // BOOTID.UPNP.ORG header, changes every time the Device reboots (if available).
+	ConfigId string        // CONFIGID.UPNP.ORG header, changes every time the description changes (if available).
+	LastSeen time.Time     // when the Device was last discovered or announced itself.
+
+	// the following fields come from the UPnP device description and are
+	// optional, i.e. they may be empty.
+	Manufacturer     string    // UPnP manufacturer field.
+	ModelName        string    // UPnP modelName field.
+	ModelNumber      string    // UPnP modelNumber field.
+	SerialNumber     string    // UPnP serialNumber field.
+	UniqueDeviceName string    // UPnP UDN field, usually uuid:<uuid>.
+	PresentationUrl  string    // UPnP presentationURL field (absolute).
+	Icons            []Icon    // UPnP iconList field.
+	Services         []Service // UPnP serviceList field.
+}
+
+// Icon is an icon of the Device listed in the UPnP device description.
+type Icon struct {
+	Mimetype string `xml:"mimetype"`
+	Width    int    `xml:"width"`
+	Height   int    `xml:"height"`
+	Depth    int    `xml:"depth"`
+	Url      string `xml:"url"` // absolute url of the icon.
+	Path     string `xml:"-"`   // local path of the icon, set by CacheIcons().
+}
+
+// Service is a UPnP service offered by the Device listed in the UPnP device
+// description. Urls are kept as found in the description (usually relative).
+type Service struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceId   string `xml:"serviceId"`
+	ScpdUrl     string `xml:"SCPDURL"`
+	ControlUrl  string `xml:"controlURL"`
+	EventSubUrl string `xml:"eventSubURL"`
 }
 
-// Wakeup contains values of WAKEUP header from the ssdpService that can be used
+// Wakeup contains values of WAKEUP header from the SSDP M-SEARCH response that can be used
 // to WoL or WoWLAN the device.
 type Wakeup struct {
 	Mac     string        // MAC address of the device's wired or wireless network interface.
@@ -98,61 +234,433 @@ type AppInfo struct {
 	} `xml:"additionalData"`
 }
 
-// Discover discovers (unique) DIAL server devices on the network.
+// DiscoverOptions tunes the discovery.
+type DiscoverOptions struct {
+	ssdp.SearchOptions // tunes the SSDP M-SEARCH requests.
+
+	// Known are Devices already discovered (e.g. cached). A Known Device
+	// found again with the same Location, BootId and ConfigId is reused
+	// (with updated SSDP values) without fetching its UPnP description.
+	Known []*Device
+}
+
+// ListenOptions tunes ListenWithOptions(). The zero value uses the defaults.
+type ListenOptions struct {
+	// Known are Devices already discovered (e.g. cached). A Known Device
+	// announced with the same Location, BootId and ConfigId is reused
+	// (with updated SSDP values) without fetching its UPnP description.
+	// Known Devices are copied, they can be modified once
+	// ListenWithOptions() returns.
+	Known []*Device
+}
+
+// Notification types, i.e. values of the SSDP NOTIFY NTS header.
+const (
+	NotifyAlive  = ssdp.NotifyAlive  // a Device joined the network (or it's still there).
+	NotifyUpdate = ssdp.NotifyUpdate // a Device changed its description.
+	NotifyByebye = ssdp.NotifyByebye // a Device is leaving the network.
+)
+
+// Notification is a presence event about a DIAL server device received through
+// an SSDP NOTIFY message.
+type Notification struct {
+	Type              string  // NotifyAlive, NotifyUpdate or NotifyByebye.
+	UniqueServiceName string  // UniqueServiceName of the Device.
+	Device            *Device // nil for NotifyByebye.
+}
+
+// Discover discovers (unique) DIAL server devices on the network. If localAddr
+// is empty, the search is performed concurrently on every up and multicast
+// capable network interface (both IPv4 and IPv6) and each Device records the
+// local address that reached it in DiscoveryAddr.
+// Closing done stops the discovery.
 func Discover(done chan struct{}, localAddr string, timeout time.Duration) (chan *Device, error) {
+	return DiscoverWithOptions(done, localAddr, timeout, DiscoverOptions{})
+}
+
+// DiscoverContext is like Discover(), but the discovery is stopped when ctx is
+// done.
+func DiscoverContext(ctx context.Context, localAddr string, timeout time.Duration) (chan *Device, error) {
+	return DiscoverWithOptionsContext(ctx, localAddr, timeout, DiscoverOptions{})
+}
+
+// DiscoverWithOptions is like Discover(), but the discovery is tuned with opts.
+func DiscoverWithOptions(done chan struct{}, localAddr string, timeout time.Duration, opts DiscoverOptions) (chan *Device, error) {
+	// the discovery can't last more than timeout plus the time needed to
+	// fetch the descriptions, after that the context can be released.
+	ctx, cancel := context.WithTimeout(context.Background(), clamp(timeout, opts.MinTimeout(), MSearchMaxTimeout)+httpTimeout)
+	go func() {
+		defer cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}()
+	return DiscoverWithOptionsContext(ctx, localAddr, timeout, opts)
+}
+
+// DiscoverWithOptionsContext is like DiscoverWithOptions(), but the discovery
+// is stopped when ctx is done.
+func DiscoverWithOptionsContext(ctx context.Context, localAddr string, timeout time.Duration, opts DiscoverOptions) (chan *Device, error) {
+	localAddrs := []string{localAddr}
+	if localAddr == "" {
+		addrs, err := ssdp.MulticastAddrs()
+		if err != nil {
+			log.Printf("MulticastAddrs: %s", err)
+		}
+		if len(addrs) > 0 {
+			localAddrs = addrs
+		}
+	}
+
+	devCh := make(chan *Device)
+	var wg sync.WaitGroup
+	seen := newSeenServices()
+	known := make(map[string]*Device)
+	for _, dev := range opts.Known {
+		known[dev.UniqueServiceName] = dev
+	}
+	var errs []error
+	for _, laddr := range localAddrs {
+		hc, err := newHTTPClient(laddr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ssdpCh, err := ssdp.Search(ctx, laddr, []string{dialSearchTarget}, timeout, opts.SearchOptions)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		wg.Add(1)
+		go fetchDevices(ctx, &wg, seen, known, laddr, hc, ssdpCh, "", devCh)
+	}
+	if len(errs) == len(localAddrs) {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Println(err)
+	}
+
+	go func() {
+		wg.Wait()
+		close(devCh)
+	}()
+
+	return devCh, nil
+}
+
+// DiscoverHosts discovers (unique) DIAL server devices at the given hosts
+// without relying on multicast, e.g. when they are on a routed network. Each
+// host is either:
+//   - a host or host:port (port defaults to 1900), which is sent a unicast
+//     M-SEARCH request;
+//   - an http(s) url to the UPnP device description (i.e. a LOCATION), which
+//     is fetched directly (such Devices lack the WAKEUP header values).
+//
+// Each Device records in SearchHost the host (or url) it was discovered at.
+// Closing done stops the discovery.
+func DiscoverHosts(done chan struct{}, localAddr string, hosts []string, timeout time.Duration) (chan *Device, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clamp(timeout, MSearchMinTimeout, MSearchMaxTimeout)+httpTimeout)
+	go func() {
+		defer cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}()
+	return DiscoverHostsContext(ctx, localAddr, hosts, timeout)
+}
+
+// DiscoverHostsContext is like DiscoverHosts(), but the discovery is stopped
+// when ctx is done.
+func DiscoverHostsContext(ctx context.Context, localAddr string, hosts []string, timeout time.Duration) (chan *Device, error) {
 	hc, err := newHTTPClient(localAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	ssdpCh, err := mSearch(localAddr, dialSearchTarget, done, timeout)
+	// set up every search before starting to fetch descriptions, cancel
+	// the ones already started on error.
+	ctx, cancel := context.WithCancel(ctx)
+	ssdpChs := make([]chan *ssdp.Service, len(hosts))
+	for i, host := range hosts {
+		if isLocation(host) {
+			// the USN is unknown until the description is fetched.
+			ssdpChs[i] = make(chan *ssdp.Service, 1)
+			ssdpChs[i] <- &ssdp.Service{Location: host, SearchTarget: dialSearchTarget, Headers: http.Header{}}
+			close(ssdpChs[i])
+			continue
+		}
+		if ssdpChs[i], err = ssdp.SearchUnicast(ctx, localAddr, []string{host}, []string{dialSearchTarget}, timeout, ssdp.SearchOptions{}); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	devCh := make(chan *Device)
+	var wg sync.WaitGroup
+	seen := newSeenServices()
+	for i, host := range hosts {
+		wg.Add(1)
+		go fetchDevices(ctx, &wg, seen, nil, localAddr, hc, ssdpChs[i], host, devCh)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(devCh)
+	}()
+
+	return devCh, nil
+}
+
+// isLocation reports whether host is a description url rather than a host.
+func isLocation(host string) bool {
+	return strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://")
+}
+
+// seenServices keeps track of the unique service names already discovered.
+type seenServices struct {
+	mu      sync.Mutex
+	m       map[string]bool
+	pending map[string]chan struct{} // closed when the claim is done.
+}
+
+func newSeenServices() *seenServices {
+	return &seenServices{m: make(map[string]bool), pending: make(map[string]chan struct{})}
+}
+
+// add returns false if usn has already been seen.
+func (s *seenServices) add(usn string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m[usn] {
+		return false
+	}
+	s.m[usn] = true
+	return true
+}
+
+// claim returns false if usn has already been seen, otherwise the caller has
+// to call done() once it knows whether usn can be marked as seen (e.g. after
+// fetching the description). Meanwhile, other claims of usn wait, so that the
+// description isn't fetched twice, and they are retried if it couldn't be.
+func (s *seenServices) claim(ctx context.Context, usn string) bool {
+	for {
+		s.mu.Lock()
+		if s.m[usn] {
+			s.mu.Unlock()
+			return false
+		}
+		ch, ok := s.pending[usn]
+		if !ok {
+			s.pending[usn] = make(chan struct{})
+			s.mu.Unlock()
+			return true
+		}
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// done ends the claim of usn, marking it as seen if ok.
+func (s *seenServices) done(usn string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[usn] = s.m[usn] || ok
+	close(s.pending[usn])
+	delete(s.pending, usn)
+}
+
+// fetchDevices fetches the UPnP description of each (unique) DIAL service
+// received from ssdpCh and sends the resulting Devices, set up to use laddr,
+// to devCh. Services without a USN (e.g. a LOCATION given by the user) get
+// one from the UDN in the description. searchHost is recorded in the Devices.
+// The description of known Devices is not fetched again if unchanged.
+func fetchDevices(ctx context.Context, wg *sync.WaitGroup, seen *seenServices, known map[string]*Device, laddr string, hc *http.Client, ssdpCh chan *ssdp.Service, searchHost string, devCh chan *Device) {
+	defer wg.Done()
+	for service := range ssdpCh {
+		if service.SearchTarget != dialSearchTarget {
+			continue
+		}
+		wg.Add(1)
+		go func(service *ssdp.Service) {
+			defer wg.Done()
+			usn := service.UniqueServiceName
+			if usn != "" && !seen.claim(ctx, usn) {
+				return
+			}
+			dev, err := describe(ctx, hc, service, known[usn])
+			if usn != "" {
+				seen.done(usn, err == nil) // other responses are described if it failed.
+			}
+			if err != nil {
+				log.Printf("%s: %s", service.Location, err)
+				return
+			}
+			if dev.UniqueServiceName == "" {
+				if dev.UniqueDeviceName == "" {
+					log.Printf("%s: %s", service.Location, errNoUDN)
+					return
+				}
+				dev.UniqueServiceName = dev.UniqueDeviceName + "::" + dialSearchTarget
+				if !seen.add(dev.UniqueServiceName) {
+					return
+				}
+			}
+			dev.DiscoveryAddr = laddr
+			dev.SearchHost = searchHost
+			if err := dev.SetLocalAddr(laddr); err != nil {
+				log.Printf("%s: SetLocalAddr: %s", dev.FriendlyName, err)
+				return
+			}
+			log.Printf("discovered DIAL device %q via %q", dev.FriendlyName, laddr)
+			select {
+			case devCh <- dev:
+			case <-ctx.Done():
+			}
+		}(service)
+	}
+}
+
+// Listen passively listens for DIAL server devices announcing their presence
+// (or their departure) on the network with SSDP NOTIFY messages until ctx is
+// done. Device of NotifyAlive and NotifyUpdate Notifications is resolved
+// fetching the UPnP description at LOCATION.
+func Listen(ctx context.Context, localAddr string) (chan *Notification, error) {
+	return ListenWithOptions(ctx, localAddr, ListenOptions{})
+}
+
+// ListenWithOptions is like Listen(), but it's tuned with opts.
+func ListenWithOptions(ctx context.Context, localAddr string, opts ListenOptions) (chan *Notification, error) {
+	hc, err := newHTTPClient(localAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	devCh := make(chan *Device)
+	notifyCh, err := ssdp.Listen(ctx, localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*Device)
+	for _, dev := range opts.Known {
+		known[dev.UniqueServiceName] = dev.clone()
+	}
+
+	ch := make(chan *Notification)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		seen := make(map[string]bool)
-		for service := range ssdpCh {
-			if service.searchTarget != dialSearchTarget || seen[service.uniqueServiceName] {
+		// devices send NOTIFY messages in bursts and periodically, keep
+		// track of announced locations (and boot and config ids, a
+		// reboot may change service ports) to avoid fetching
+		// descriptions again and again.
+		var mu sync.Mutex
+		announced := make(map[string]string)
+		// descriptions are fetched concurrently, but the Notifications
+		// of a device are delivered in the order they were received
+		// (e.g. an ssdp:byebye after the ssdp:alive before it): each
+		// one waits for the previous one of the same device.
+		delivered := make(map[string]chan struct{})
+		for notify := range notifyCh {
+			if notify.SearchTarget != dialSearchTarget {
 				continue
 			}
-			seen[service.uniqueServiceName] = true
+			usn := notify.UniqueServiceName
+			mu.Lock()
+			key := notify.Location + " " + bootId(notify.Headers) + " " + configId(notify.Headers)
+			prevKey, seen := announced[usn]
+			switch notify.Type {
+			case ssdp.NotifyByebye:
+				delete(announced, usn)
+			case ssdp.NotifyAlive:
+				if seen && prevKey == key {
+					mu.Unlock()
+					continue
+				}
+				fallthrough
+			default:
+				announced[usn] = key
+			}
+			prev, done := delivered[usn], make(chan struct{})
+			delivered[usn] = done
+			mu.Unlock()
+
 			wg.Add(1)
-			go func(service *ssdpService) {
+			go func(notify *ssdp.Notify) {
 				defer wg.Done()
-				respBody, headers, err := doReq(hc, "GET", service.location, "", "")
-				if err != nil {
-					log.Println(err)
-					return
+				waitPrev := func() bool {
+					if prev == nil {
+						return true
+					}
+					select {
+					case <-prev:
+						return true
+					case <-ctx.Done():
+						return false
+					}
 				}
-				dev, err := parseDevice(service, respBody, headers)
-				if err != nil {
-					log.Printf("%s: parseDevice: %s", service.location, err)
-					return
+				defer func() {
+					waitPrev() // even on error, the next one can't overtake it.
+					close(done)
+					mu.Lock()
+					if delivered[usn] == done {
+						delete(delivered, usn)
+					}
+					mu.Unlock()
+				}()
+				n := &Notification{Type: notify.Type, UniqueServiceName: usn}
+				if notify.Type != ssdp.NotifyByebye {
+					var err error
+					n.Device, err = describe(ctx, hc, notify.Service, known[usn])
+					if err == nil {
+						err = n.Device.SetLocalAddr(localAddr)
+					}
+					if err != nil {
+						log.Printf("%s: %s", notify.Location, err)
+						mu.Lock()
+						if announced[usn] == key {
+							delete(announced, usn) // retry on next NOTIFY.
+						}
+						mu.Unlock()
+						return
+					}
 				}
-				if err := dev.SetLocalAddr(localAddr); err != nil {
-					log.Printf("%s: SetLocalAddr: %s", dev.FriendlyName, err)
+				if !waitPrev() {
 					return
 				}
-				log.Printf("discovered DIAL device %q", dev.FriendlyName)
+				log.Printf("%s %s", n.Type, n.UniqueServiceName)
 				select {
-				case devCh <- dev:
-				case <-done:
+				case ch <- n:
+				case <-ctx.Done():
 				}
-			}(service)
+			}(notify)
 		}
 	}()
 
 	go func() {
 		wg.Wait()
-		close(devCh)
+		close(ch)
 	}()
 
-	return devCh, nil
+	return ch, nil
+}
+
+func clamp(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
 }
 
 func newHTTPClient(localAddr string) (*http.Client, error) {
@@ -173,8 +681,8 @@ func newHTTPClient(localAddr string) (*http.Client, error) {
 	return hc, nil
 }
 
-func doReq(httpClient *http.Client, method, url string, origin, body string) ([]byte, http.Header, error) {
-	req, err := http.NewRequest(method, url, strings.NewReader(body))
+func doReq(ctx context.Context, httpClient *http.Client, method, url string, origin, body string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -193,34 +701,180 @@ func doReq(httpClient *http.Client, method, url string, origin, body string) ([]
 	defer resp.Body.Close()
 	respBody, err := io.ReadAll(resp.Body)
 	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
-		err = fmt.Errorf("%s %s: %s: %w", method, url, resp.Status, errBadHttpStatus)
+		err = newStatusError(method, url, resp, respBody)
 	}
 	return respBody, resp.Header, err
 }
 
-func parseDevice(service *ssdpService, desc []byte, descHeaders http.Header) (*Device, error) {
+// describe returns the Device which offers service, fetching its UPnP
+// description unless known (the same Device already discovered, may be nil)
+// has the same description.
+func describe(ctx context.Context, hc *http.Client, service *ssdp.Service, known *Device) (*Device, error) {
+	if known != nil && known.sameDescription(service) {
+		log.Printf("%q description unchanged (CONFIGID %s), not fetching it", known.FriendlyName, known.ConfigId)
+		dev := known.clone()
+		dev.setSSDPHeaders(service.Headers)
+		return dev, nil
+	}
+	if known != nil && known.BootId != "" && bootId(service.Headers) != "" && known.BootId != bootId(service.Headers) {
+		log.Printf("%q rebooted (BOOTID %s -> %s)", known.FriendlyName, known.BootId, bootId(service.Headers))
+	}
+	respBody, headers, err := doReq(ctx, hc, "GET", service.Location, "", "")
+	if err != nil {
+		return nil, err
+	}
+	dev, err := parseDevice(service, respBody, headers)
+	if err != nil {
+		return nil, fmt.Errorf("parseDevice: %w", err)
+	}
+	return dev, nil
+}
+
+func parseDevice(service *ssdp.Service, desc []byte, descHeaders http.Header) (*Device, error) {
 	appUrl := strings.TrimSpace(descHeaders.Get("Application-URL"))
 	if appUrl == "" {
 		return nil, errNoAppUrl
 	}
+	appUrl = ssdp.WithZone(appUrl, service.Zone)
 
 	var v struct {
-		FriendlyName string `xml:"device>friendlyName"`
+		UrlBase string `xml:"URLBase"`
+		Device  struct {
+			FriendlyName    string    `xml:"friendlyName"`
+			Manufacturer    string    `xml:"manufacturer"`
+			ModelName       string    `xml:"modelName"`
+			ModelNumber     string    `xml:"modelNumber"`
+			SerialNumber    string    `xml:"serialNumber"`
+			UDN             string    `xml:"UDN"`
+			PresentationUrl string    `xml:"presentationURL"`
+			Icons           []Icon    `xml:"iconList>icon"`
+			Services        []Service `xml:"serviceList>service"`
+		} `xml:"device"`
 	}
 	if err := xml.Unmarshal(desc, &v); err != nil {
 		return nil, err
 	}
 
+	// relative urls in the description are relative to URLBase (deprecated
+	// since UPnP 1.1) or to the url the description was fetched from.
+	base := strings.TrimSpace(v.UrlBase)
+	if base == "" {
+		base = service.Location
+	}
+	for i := range v.Device.Icons {
+		v.Device.Icons[i].Url = urlResolve(base, strings.TrimSpace(v.Device.Icons[i].Url))
+	}
+	presentationUrl := strings.TrimSpace(v.Device.PresentationUrl)
+	if presentationUrl != "" {
+		presentationUrl = urlResolve(base, presentationUrl)
+	}
+
 	dev := &Device{
-		UniqueServiceName: service.uniqueServiceName,
-		Location:          service.location,
+		UniqueServiceName: service.UniqueServiceName,
+		Location:          service.Location,
 		ApplicationUrl:    appUrl,
-		FriendlyName:      v.FriendlyName,
-		Wakeup:            parseWakeup(service.headers.Get("WAKEUP")),
+		FriendlyName:      strings.TrimSpace(v.Device.FriendlyName),
+		Manufacturer:      strings.TrimSpace(v.Device.Manufacturer),
+		ModelName:         strings.TrimSpace(v.Device.ModelName),
+		ModelNumber:       strings.TrimSpace(v.Device.ModelNumber),
+		SerialNumber:      strings.TrimSpace(v.Device.SerialNumber),
+		UniqueDeviceName:  strings.TrimSpace(v.Device.UDN),
+		PresentationUrl:   presentationUrl,
+		Icons:             v.Device.Icons,
+		Services:          v.Device.Services,
 	}
+	dev.setSSDPHeaders(service.Headers)
 	return dev, nil
 }
 
+// setSSDPHeaders sets the Device fields that come from the headers h of an
+// SSDP M-SEARCH response (or NOTIFY message) and marks it as just seen.
+func (d *Device) setSSDPHeaders(h http.Header) {
+	d.Wakeup = parseWakeup(h.Get("WAKEUP"))
+	d.MaxAge = parseMaxAge(h.Get("CACHE-CONTROL"))
+	d.BootId = bootId(h)
+	d.ConfigId = configId(h)
+	d.LastSeen = time.Now()
+}
+
+// parseMaxAge returns the max-age directive of a CACHE-CONTROL header value,
+// e.g. "max-age = 1800", or 0 if missing or invalid.
+func parseMaxAge(v string) time.Duration {
+	for _, directive := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(directive, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "max-age") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+		if err != nil || secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
+
+func bootId(h http.Header) string {
+	return strings.TrimSpace(h.Get("BOOTID.UPNP.ORG"))
+}
+
+func configId(h http.Header) string {
+	return strings.TrimSpace(h.Get("CONFIGID.UPNP.ORG"))
+}
+
+// sameDescription reports whether the description of service is the one
+// already fetched for the Device, i.e. Location didn't change and the Device
+// neither rebooted (BOOTID) nor changed its description (CONFIGID). Devices
+// without CONFIGID can't tell, so the description is assumed changed.
+func (d *Device) sameDescription(service *ssdp.Service) bool {
+	return d.ConfigId != "" && d.Location == service.Location &&
+		d.BootId == bootId(service.Headers) && d.ConfigId == configId(service.Headers)
+}
+
+// clone returns a copy of the Device that can be modified (e.g. by
+// CacheIcons()) without affecting d.
+func (d *Device) clone() *Device {
+	dev := *d
+	dev.Icons = slices.Clone(d.Icons)
+	dev.Services = slices.Clone(d.Services)
+	return &dev
+}
+
+// Refresh updates d with dev, the same Device discovered (or announced) again,
+// keeping the values dev lacks: Wakeup (missing if dev was reached at its
+// LOCATION or announced without the WAKEUP header), DiscoveryAddr, SearchHost
+// and the local paths of the icons whose url didn't change. d uses the local
+// address of dev for network operations, if set.
+func (d *Device) Refresh(dev *Device) {
+	prev := d.clone()
+	*d = *dev.clone()
+	if d.httpClient == nil {
+		d.localAddr, d.httpClient = prev.localAddr, prev.httpClient
+	}
+	if d.Wakeup.Mac == "" {
+		d.Wakeup = prev.Wakeup
+	}
+	if d.DiscoveryAddr == "" {
+		d.DiscoveryAddr = prev.DiscoveryAddr
+	}
+	if d.SearchHost == "" {
+		d.SearchHost = prev.SearchHost
+	}
+	for i := range d.Icons {
+		icon := &d.Icons[i]
+		if j := slices.IndexFunc(prev.Icons, func(p Icon) bool { return p.Url == icon.Url }); icon.Path == "" && j >= 0 {
+			icon.Path = prev.Icons[j].Path
+		}
+	}
+}
+
+// Expired reports whether the Device announcement expired, i.e. the Device has
+// not been seen for more than MaxAge and it may have left the network (or
+// changed address). Devices with unknown MaxAge or LastSeen never expire.
+func (d *Device) Expired() bool {
+	return d.MaxAge > 0 && !d.LastSeen.IsZero() && time.Since(d.LastSeen) > d.MaxAge
+}
+
 func parseWakeup(v string) Wakeup {
 	if v == "" {
 		return Wakeup{}
@@ -238,8 +892,16 @@ func parseWakeup(v string) Wakeup {
 }
 
 // SetLocalAddr sets the local address the Device instance must use for network
-// operations.
+// operations. If localAddr is empty, DiscoveryAddr is used as long as it's
+// still assigned to a local network interface.
 func (d *Device) SetLocalAddr(localAddr string) error {
+	if localAddr == "" && d.DiscoveryAddr != "" {
+		if ip, _, err := net.SplitHostPort(d.DiscoveryAddr); err == nil {
+			if _, err := ssdp.InterfaceByIP(net.ParseIP(ip)); err == nil {
+				localAddr = d.DiscoveryAddr
+			}
+		}
+	}
 	if d.httpClient != nil && d.localAddr == localAddr {
 		return nil // localAddr already set, no need to recreate an http.Client.
 	}
@@ -256,11 +918,16 @@ func (d *Device) SetLocalAddr(localAddr string) error {
 // appName should be an application name registered in the DIAL Registry.
 // origin (if present) will be passed as Origin HTTP header.
 func (d *Device) GetAppInfo(appName, origin string) (*AppInfo, error) {
+	return d.GetAppInfoContext(context.Background(), appName, origin)
+}
+
+// GetAppInfoContext is like GetAppInfo(), but accepts a context.Context.
+func (d *Device) GetAppInfoContext(ctx context.Context, appName, origin string) (*AppInfo, error) {
 	u, err := urlJoin(d.ApplicationUrl, appName)
 	if err != nil {
 		return nil, err
 	}
-	respBody, _, err := doReq(d.httpClient, "GET", u, origin, "")
+	respBody, _, err := doReq(ctx, d.httpClient, "GET", u, origin, "")
 	if err != nil {
 		return nil, err
 	}
@@ -280,6 +947,72 @@ func urlJoin(base, end string) (string, error) {
 	return u.String(), nil
 }
 
+func urlResolve(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// CacheIcons downloads the Device Icons in dir and sets their Path. Icons
+// already downloaded (i.e. whose file already exists in dir) are not
+// downloaded again.
+func (d *Device) CacheIcons(dir string) error {
+	return d.CacheIconsContext(context.Background(), dir)
+}
+
+// CacheIconsContext is like CacheIcons(), but accepts a context.Context.
+func (d *Device) CacheIconsContext(ctx context.Context, dir string) error {
+	var errs []error
+	for i := range d.Icons {
+		icon := &d.Icons[i]
+		fpath := filepath.Join(dir, d.iconFileName(icon))
+		if _, err := os.Stat(fpath); err == nil {
+			icon.Path = fpath
+			continue
+		}
+		data, _, err := doReq(ctx, d.httpClient, "GET", icon.Url, "", "")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(fpath, data, 0644); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		icon.Path = fpath
+	}
+	return errors.Join(errs...)
+}
+
+// iconFileName returns a file name for icon which is unique among all
+// devices, e.g. uuid_12345678-abcd-48x48x24.png
+func (d *Device) iconFileName(icon *Icon) string {
+	id := d.UniqueDeviceName
+	if id == "" {
+		id = d.UniqueServiceName
+	}
+	var ext string
+	if u, err := url.Parse(icon.Url); err == nil {
+		ext = path.Ext(u.Path)
+	}
+	if exts, _ := mime.ExtensionsByType(icon.Mimetype); ext == "" && len(exts) > 0 {
+		ext = exts[0]
+	}
+	safe := func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.') {
+			return '_'
+		}
+		return r
+	}
+	return strings.Map(safe, fmt.Sprintf("%s-%dx%dx%d%s", id, icon.Width, icon.Height, icon.Depth, ext))
+}
+
 // Launch launches (starts) an application on the Device and returns its
 // instance url (if available).
 // appName should be an application name registered in the DIAL Registry.
@@ -287,15 +1020,155 @@ func urlJoin(base, end string) (string, error) {
 // payload (if present) will be passed as HTTP message body with
 // Content-Type: text/plain; charset=utf-8 header.
 func (d *Device) Launch(appName, origin, payload string) (string, error) {
-	u, err := urlJoin(d.ApplicationUrl, appName)
+	return d.LaunchContext(context.Background(), appName, origin, payload)
+}
+
+// LaunchContext is like Launch(), but accepts a context.Context.
+func (d *Device) LaunchContext(ctx context.Context, appName, origin, payload string) (string, error) {
+	res, err := d.LaunchWithOptionsContext(ctx, appName, origin, payload, LaunchOptions{})
 	if err != nil {
 		return "", err
 	}
-	_, headers, err := doReq(d.httpClient, "POST", u, origin, payload)
+	return res.InstanceUrl, nil
+}
+
+// LaunchOptions contains optional DIAL 2.x launch parameters.
+type LaunchOptions struct {
+	// FriendlyName is the name of the launching client which the Device
+	// may display, passed as friendlyName query parameter.
+	FriendlyName string
+
+	// ClientDialVer is the DIAL version supported by the client, passed as
+	// clientDialVer query parameter (e.g. ClientDialVer).
+	ClientDialVer string
+
+	// Callback (if not nil) allocates an additionalDataUrl which is
+	// appended to the payload and where the application can POST its
+	// additionalData.
+	Callback *CallbackServer
+}
+
+// LaunchResult is the result of LaunchWithOptions().
+type LaunchResult struct {
+	// InstanceUrl is the instance url of the launched application (if
+	// available).
+	InstanceUrl string
+
+	// AdditionalData receives the additionalData POSTed by the
+	// application to the additionalDataUrl. It's nil if
+	// LaunchOptions.Callback was nil.
+	AdditionalData <-chan string
+}
+
+// LaunchWithOptions is like Launch(), but accepts DIAL 2.x LaunchOptions.
+func (d *Device) LaunchWithOptions(appName, origin, payload string, opts LaunchOptions) (*LaunchResult, error) {
+	return d.LaunchWithOptionsContext(context.Background(), appName, origin, payload, opts)
+}
+
+// LaunchWithOptionsContext is like LaunchWithOptions(), but accepts a
+// context.Context.
+func (d *Device) LaunchWithOptionsContext(ctx context.Context, appName, origin, payload string, opts LaunchOptions) (*LaunchResult, error) {
+	u, err := urlJoin(d.ApplicationUrl, appName)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	q := url.Values{}
+	if opts.FriendlyName != "" {
+		q.Set("friendlyName", opts.FriendlyName)
+	}
+	if opts.ClientDialVer != "" {
+		q.Set("clientDialVer", opts.ClientDialVer)
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	res := &LaunchResult{}
+	release := func() {}
+	if opts.Callback != nil {
+		var dataUrl string
+		var dataCh chan string
+		if dataUrl, dataCh, release, err = opts.Callback.allocate(d.ApplicationUrl); err != nil {
+			return nil, fmt.Errorf("CallbackServer: %w", err)
+		}
+		if payload != "" {
+			payload += "&"
+		}
+		payload += "additionalDataUrl=" + url.QueryEscape(dataUrl)
+		res.AdditionalData = dataCh
 	}
-	return headers.Get("Location"), nil
+	_, headers, err := doReq(ctx, d.httpClient, "POST", u, origin, payload)
+	if err != nil {
+		release() // nobody is going to receive the data.
+		return nil, err
+	}
+	res.InstanceUrl = headers.Get("Location")
+	return res, nil
+}
+
+// InstallUrl returns the url to GET to install the application if its State
+// is installable=<URL>, otherwise an empty string.
+func (a *AppInfo) InstallUrl() string {
+	installUrl, ok := strings.CutPrefix(strings.TrimSpace(a.State), "installable=")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(installUrl)
+}
+
+// Install triggers the installation of an application on the Device sending a
+// GET request to installUrl (see AppInfo.InstallUrl()). The installation
+// happens asynchronously, GetAppInfo() can be used to check its progress.
+// origin (if present) will be passed as Origin HTTP header.
+func (d *Device) Install(installUrl, origin string) error {
+	return d.InstallContext(context.Background(), installUrl, origin)
+}
+
+// InstallContext is like Install(), but accepts a context.Context.
+func (d *Device) InstallContext(ctx context.Context, installUrl, origin string) error {
+	if installUrl == "" {
+		return errNoInstallUrl
+	}
+	_, _, err := doReq(ctx, d.httpClient, "GET", installUrl, origin, "")
+	return err
+}
+
+// Stop stops an application running on the Device sending a DELETE request to
+// its instance url.
+// appName should be an application name registered in the DIAL Registry.
+// origin (if present) will be passed as Origin HTTP header.
+// Returns ErrNotRunning if the application is not running and
+// ErrStopNotAllowed if the application doesn't support being stopped.
+func (d *Device) Stop(appName, origin string) error {
+	return d.StopContext(context.Background(), appName, origin)
+}
+
+// StopContext is like Stop(), but accepts a context.Context.
+func (d *Device) StopContext(ctx context.Context, appName, origin string) error {
+	appInfo, err := d.GetAppInfoContext(ctx, appName, origin)
+	if err != nil {
+		return err
+	}
+	if appInfo.State != "running" && appInfo.State != "hidden" {
+		return ErrNotRunning
+	}
+	if !appInfo.Options.AllowStop {
+		return ErrStopNotAllowed
+	}
+	if appInfo.Link.Href == "" {
+		return errNoInstanceUrl
+	}
+	appUrl, err := urlJoin(d.ApplicationUrl, appName)
+	if err != nil {
+		return err
+	}
+	// Href is usually relative to the application url, i.e. appUrl/run.
+	instanceUrl := urlResolve(appUrl+"/", appInfo.Link.Href)
+	_, _, err = doReq(ctx, d.httpClient, "DELETE", instanceUrl, origin, "")
+	var se *StatusError
+	if errors.As(err, &se) && se.Code == http.StatusMethodNotAllowed {
+		return fmt.Errorf("%w: %w", ErrStopNotAllowed, err)
+	}
+	return err
 }
 
 // TryWakeup tries to Wake-On-Lan the Device sending magic packets to its MAC
@@ -304,27 +1177,63 @@ func (d *Device) Launch(appName, origin, payload string) (string, error) {
 // ip address and/or service ports.
 // Returns nil if it successfully wakes up the Device.
 func (d *Device) TryWakeup() error {
+	return d.TryWakeupContext(context.Background())
+}
+
+// TryWakeupContext is like TryWakeup(), but gives up when ctx is done.
+func (d *Device) TryWakeupContext(ctx context.Context) error {
+	return d.TryWakeupWithOptionsContext(ctx, WakeOptions{})
+}
+
+// TryWakeupWithOptions is like TryWakeup(), but the magic packets are tuned
+// with opts. Unless opts.Addrs is set, they are sent to the limited broadcast
+// address and to the subnet-directed broadcast addresses of the local network
+// interface in use (or of all of them).
+func (d *Device) TryWakeupWithOptions(opts WakeOptions) error {
+	return d.TryWakeupWithOptionsContext(context.Background(), opts)
+}
+
+// TryWakeupWithOptionsContext is like TryWakeupWithOptions(), but gives up
+// when ctx is done.
+func (d *Device) TryWakeupWithOptionsContext(ctx context.Context, opts WakeOptions) error {
 	if d.Wakeup.Mac == "" {
 		return errNoMac
 	}
-	done := make(chan struct{})
-	defer close(done)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the last Discover.
 	timeout := clamp(d.Wakeup.Timeout*2, wakeupMinTimeout, wakeupMaxTimeout)
+	wolAddr := d.localAddr
+	if ssdp.IsIPv6Addr(wolAddr) {
+		wolAddr = "" // magic packets are sent to IPv4 broadcast addresses.
+	}
+	baddrs := opts.Addrs
+	if len(baddrs) == 0 {
+		baddrs = broadcastAddrs(wolAddr)
+	}
 	for start := time.Now(); time.Since(start) < timeout; {
-		if err := wakeOnLan(d.Wakeup.Mac, d.localAddr, wakeupBroadcastAddr); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := wakeOnLan(ctx, d.Wakeup.Mac, wolAddr, baddrs, opts); err != nil {
 			return err
 		}
-		if d.Ping() {
+		if d.PingContext(ctx) {
 			return nil
 		}
 		// Ping() may have failed because the device changed ip or port.
-		devCh, err := Discover(done, d.localAddr, MSearchMinTimeout+1*time.Second)
+		var devCh chan *Device
+		var err error
+		if d.SearchHost != "" {
+			devCh, err = DiscoverHostsContext(ctx, d.localAddr, []string{d.SearchHost}, MSearchMinTimeout+1*time.Second)
+		} else {
+			devCh, err = DiscoverContext(ctx, d.localAddr, MSearchMinTimeout+1*time.Second)
+		}
 		if err != nil {
 			return fmt.Errorf("Discover: %w", err)
 		}
 		for updatedDev := range devCh {
 			if updatedDev.UniqueServiceName == d.UniqueServiceName {
-				*d = *updatedDev
+				d.Refresh(updatedDev)
 				return nil
 			}
 		}
@@ -334,7 +1243,12 @@ func (d *Device) TryWakeup() error {
 
 // Ping returns true if the Device is up i.e. if it responds to requests.
 func (d *Device) Ping() bool {
-	_, _, err := doReq(d.httpClient, "GET", d.ApplicationUrl, "", "")
+	return d.PingContext(context.Background())
+}
+
+// PingContext is like Ping(), but accepts a context.Context.
+func (d *Device) PingContext(ctx context.Context) bool {
+	_, _, err := doReq(ctx, d.httpClient, "GET", d.ApplicationUrl, "", "")
 	if err != nil && errors.Is(err, errBadHttpStatus) {
 		return true
 	}
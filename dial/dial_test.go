@@ -5,18 +5,26 @@ package dial
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/MarcoLucidi01/ytcast/ssdp"
 )
 
 func TestParseDevice(t *testing.T) {
 	tests := []struct {
 		resp    []byte
 		mustErr bool
-		service *ssdpService
+		service *ssdp.Service
 		device  *Device
 	}{
 		{
@@ -36,7 +44,26 @@ func TestParseDevice(t *testing.T) {
 				    <friendlyName>Friendly FOO BAR</friendlyName>
 				    <manufacturer>FOO</manufacturer>
 				    <modelName>BAR</modelName>
+				    <modelNumber>BAZ-42</modelNumber>
+				    <serialNumber>SN123</serialNumber>
 				    <UDN>device-UUID</UDN>
+				    <presentationURL>/index.html</presentationURL>
+				    <iconList>
+				      <icon>
+					<mimetype>image/png</mimetype>
+					<width>48</width>
+					<height>48</height>
+					<depth>24</depth>
+					<url>/icons/48.png</url>
+				      </icon>
+				      <icon>
+					<mimetype>image/jpeg</mimetype>
+					<width>120</width>
+					<height>120</height>
+					<depth>24</depth>
+					<url>http://192.168.1.1:8080/icons/120.jpg</url>
+				      </icon>
+				    </iconList>
 				    <serviceList>
 				      <service>
 					<serviceType>urn:dial-multiscreen-org:service:dial:1</serviceType>
@@ -49,13 +76,16 @@ func TestParseDevice(t *testing.T) {
 				  </device>
 				</root>`),
 			mustErr: false,
-			service: &ssdpService{
-				uniqueServiceName: "device-UUID",
-				location:          "http://192.168.1.1:52235/dd.xml",
-				searchTarget:      "urn:dial-multiscreen-org:service:dial:1",
-				headers: map[string][]string{
-					"Server": []string{"OS/version UPnP/1.1 product/version"},
-					"Wakeup": []string{"MAC=10:dd:b1:c9:00:e4;Timeout=60"},
+			service: &ssdp.Service{
+				UniqueServiceName: "device-UUID",
+				Location:          "http://192.168.1.1:52235/dd.xml",
+				SearchTarget:      "urn:dial-multiscreen-org:service:dial:1",
+				Headers: map[string][]string{
+					"Server":            []string{"OS/version UPnP/1.1 product/version"},
+					"Wakeup":            []string{"MAC=10:dd:b1:c9:00:e4;Timeout=60"},
+					"Cache-Control":     []string{"max-age=1800"},
+					"Bootid.upnp.org":   []string{"7"},
+					"Configid.upnp.org": []string{"42"},
 				},
 			},
 			device: &Device{
@@ -67,6 +97,28 @@ func TestParseDevice(t *testing.T) {
 					Mac:     "10:dd:b1:c9:00:e4",
 					Timeout: 60 * time.Second,
 				},
+				MaxAge:           1800 * time.Second,
+				BootId:           "7",
+				ConfigId:         "42",
+				Manufacturer:     "FOO",
+				ModelName:        "BAR",
+				ModelNumber:      "BAZ-42",
+				SerialNumber:     "SN123",
+				UniqueDeviceName: "device-UUID",
+				PresentationUrl:  "http://192.168.1.1:52235/index.html",
+				Icons: []Icon{
+					{Mimetype: "image/png", Width: 48, Height: 48, Depth: 24, Url: "http://192.168.1.1:52235/icons/48.png"},
+					{Mimetype: "image/jpeg", Width: 120, Height: 120, Depth: 24, Url: "http://192.168.1.1:8080/icons/120.jpg"},
+				},
+				Services: []Service{
+					{
+						ServiceType: "urn:dial-multiscreen-org:service:dial:1",
+						ServiceId:   "urn:dial-multiscreen-org:serviceId:dial",
+						ScpdUrl:     "/upnp/dev/device-UUID/svc/dial-multiscreen-org/dial/desc",
+						ControlUrl:  "/upnp/dev/device-UUID/svc/dial-multiscreen-org/dial/action",
+						EventSubUrl: "/upnp/dev/device-UUID/svc/dial-multiscreen-org/dial/event",
+					},
+				},
 			},
 		}, {
 			resp: []byte("HTTP/1.1 200 OK\r\n" +
@@ -112,6 +164,96 @@ func TestParseDevice(t *testing.T) {
 		if test.device.Wakeup.Timeout != device.Wakeup.Timeout {
 			t.Fatalf("tests[%d]: device.Wakeup.Timeout: want %d got %d", i, test.device.Wakeup.Timeout, device.Wakeup.Timeout)
 		}
+		if test.device.MaxAge != device.MaxAge {
+			t.Fatalf("tests[%d]: device.MaxAge: want %s got %s", i, test.device.MaxAge, device.MaxAge)
+		}
+		if test.device.BootId != device.BootId {
+			t.Fatalf("tests[%d]: device.BootId: want %q got %q", i, test.device.BootId, device.BootId)
+		}
+		if test.device.ConfigId != device.ConfigId {
+			t.Fatalf("tests[%d]: device.ConfigId: want %q got %q", i, test.device.ConfigId, device.ConfigId)
+		}
+		if device.LastSeen.IsZero() {
+			t.Fatalf("tests[%d]: device.LastSeen: want now got zero", i)
+		}
+		if test.device.Manufacturer != device.Manufacturer {
+			t.Fatalf("tests[%d]: device.Manufacturer: want %q got %q", i, test.device.Manufacturer, device.Manufacturer)
+		}
+		if test.device.ModelName != device.ModelName {
+			t.Fatalf("tests[%d]: device.ModelName: want %q got %q", i, test.device.ModelName, device.ModelName)
+		}
+		if test.device.ModelNumber != device.ModelNumber {
+			t.Fatalf("tests[%d]: device.ModelNumber: want %q got %q", i, test.device.ModelNumber, device.ModelNumber)
+		}
+		if test.device.SerialNumber != device.SerialNumber {
+			t.Fatalf("tests[%d]: device.SerialNumber: want %q got %q", i, test.device.SerialNumber, device.SerialNumber)
+		}
+		if test.device.UniqueDeviceName != device.UniqueDeviceName {
+			t.Fatalf("tests[%d]: device.UniqueDeviceName: want %q got %q", i, test.device.UniqueDeviceName, device.UniqueDeviceName)
+		}
+		if test.device.PresentationUrl != device.PresentationUrl {
+			t.Fatalf("tests[%d]: device.PresentationUrl: want %q got %q", i, test.device.PresentationUrl, device.PresentationUrl)
+		}
+		if len(test.device.Icons) != len(device.Icons) {
+			t.Fatalf("tests[%d]: len(device.Icons): want %d got %d", i, len(test.device.Icons), len(device.Icons))
+		}
+		for j := range test.device.Icons {
+			if test.device.Icons[j] != device.Icons[j] {
+				t.Fatalf("tests[%d]: device.Icons[%d]: want %+v got %+v", i, j, test.device.Icons[j], device.Icons[j])
+			}
+		}
+		if len(test.device.Services) != len(device.Services) {
+			t.Fatalf("tests[%d]: len(device.Services): want %d got %d", i, len(test.device.Services), len(device.Services))
+		}
+		for j := range test.device.Services {
+			if test.device.Services[j] != device.Services[j] {
+				t.Fatalf("tests[%d]: device.Services[%d]: want %+v got %+v", i, j, test.device.Services[j], device.Services[j])
+			}
+		}
+	}
+}
+
+func TestCacheIcons(t *testing.T) {
+	iconData := []byte("\x89PNG fake icon")
+	var reqs int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs++
+		w.Write(iconData)
+	}))
+	defer srv.Close()
+
+	dev := &Device{
+		UniqueServiceName: "uuid:foo-bar::urn:dial-multiscreen-org:service:dial:1",
+		UniqueDeviceName:  "uuid:foo-bar",
+		Icons: []Icon{
+			{Mimetype: "image/png", Width: 48, Height: 48, Depth: 24, Url: srv.URL + "/icons/48.png"},
+			{Mimetype: "image/png", Width: 120, Height: 120, Depth: 24, Url: srv.URL + "/icon?size=120"},
+		},
+	}
+	if err := dev.SetLocalAddr(""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dir := t.TempDir()
+	for n := 0; n < 2; n++ { // second time icons must be taken from dir.
+		if err := dev.CacheIcons(dir); err != nil {
+			t.Fatalf("%d: unexpected error: %s", n, err)
+		}
+		if reqs != len(dev.Icons) {
+			t.Fatalf("%d: requests: want %d got %d", n, len(dev.Icons), reqs)
+		}
+	}
+	want := []string{"uuid_foo-bar-48x48x24.png", "uuid_foo-bar-120x120x24.png"}
+	for i, icon := range dev.Icons {
+		if filepath.Base(icon.Path) != want[i] {
+			t.Fatalf("dev.Icons[%d].Path: want %q got %q", i, want[i], filepath.Base(icon.Path))
+		}
+		data, err := os.ReadFile(icon.Path)
+		if err != nil {
+			t.Fatalf("dev.Icons[%d]: unexpected error: %s", i, err)
+		}
+		if !bytes.Equal(iconData, data) {
+			t.Fatalf("dev.Icons[%d]: want %q got %q", i, iconData, data)
+		}
 	}
 }
 
@@ -206,6 +348,131 @@ func TestUnmarshalAppInfo(t *testing.T) {
 	}
 }
 
+func TestInstallUrl(t *testing.T) {
+	tests := []struct {
+		state      string
+		installUrl string
+	}{
+		{"running", ""},
+		{"stopped", ""},
+		{"installable=https://store.example.com/apps/youtube", "https://store.example.com/apps/youtube"},
+		{" installable= http://192.168.1.1:8080/install?app=YouTube ", "http://192.168.1.1:8080/install?app=YouTube"},
+		{"installable=", ""},
+	}
+
+	for i, test := range tests {
+		app := &AppInfo{State: test.state}
+		if got := app.InstallUrl(); got != test.installUrl {
+			t.Fatalf("tests[%d]: InstallUrl(): want %q got %q", i, test.installUrl, got)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	tests := []struct {
+		appInfo      string
+		deleteStatus int
+		deleted      bool
+		err          error
+	}{
+		{
+			appInfo:      `<service><name>YouTube</name><options allowStop="true"/><state>running</state><link rel="run" href="run"/></service>`,
+			deleteStatus: http.StatusOK,
+			deleted:      true,
+		}, {
+			appInfo:      `<service><name>YouTube</name><options allowStop="true"/><state>running</state><link rel="run" href="run"/></service>`,
+			deleteStatus: http.StatusMethodNotAllowed,
+			deleted:      true,
+			err:          ErrStopNotAllowed,
+		}, {
+			appInfo: `<service><name>YouTube</name><options allowStop="false"/><state>running</state><link rel="run" href="run"/></service>`,
+			err:     ErrStopNotAllowed,
+		}, {
+			appInfo: `<service><name>YouTube</name><options allowStop="true"/><state>stopped</state></service>`,
+			err:     ErrNotRunning,
+		}, {
+			appInfo: `<service><name>YouTube</name><options allowStop="true"/><state>running</state></service>`,
+			err:     errNoInstanceUrl,
+		},
+	}
+
+	for i, test := range tests {
+		var deleted bool
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/apps/YouTube":
+				io.WriteString(w, test.appInfo)
+			case r.Method == "DELETE" && r.URL.Path == "/apps/YouTube/run":
+				deleted = true
+				w.WriteHeader(test.deleteStatus)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		dev := &Device{ApplicationUrl: srv.URL + "/apps"}
+		if err := dev.SetLocalAddr(""); err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		err := dev.Stop("YouTube", "https://www.youtube.com")
+		srv.Close()
+		if test.err == nil && err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Fatalf("tests[%d]: err: want %q got %v", i, test.err, err)
+		}
+		if test.deleted != deleted {
+			t.Fatalf("tests[%d]: deleted: want %t got %t", i, test.deleted, deleted)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		code int
+		body string
+		err  error
+		want string
+	}{
+		{http.StatusNotFound, "", ErrAppNotFound, ""},
+		{http.StatusForbidden, "origin\n  not allowed\n", ErrOriginRejected, "origin not allowed"},
+		{http.StatusRequestEntityTooLarge, "", ErrPayloadTooLarge, ""},
+		{http.StatusNotImplemented, "", ErrNotImplemented, ""},
+		{http.StatusServiceUnavailable, "", ErrAppUnavailable, ""},
+		{http.StatusInternalServerError, strings.Repeat("x", 200), nil, strings.Repeat("x", statusErrorMaxBody) + "..."},
+	}
+
+	for i, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.code)
+			io.WriteString(w, test.body)
+		}))
+		_, _, err := doReq(context.Background(), srv.Client(), "POST", srv.URL+"/apps/YouTube", "", "")
+		srv.Close()
+		var se *StatusError
+		if !errors.As(err, &se) {
+			t.Fatalf("tests[%d]: want StatusError got %v", i, err)
+		}
+		if se.Code != test.code || se.Method != "POST" || se.Url != srv.URL+"/apps/YouTube" {
+			t.Fatalf("tests[%d]: StatusError: unexpected %+v", i, se)
+		}
+		if se.Body != test.want {
+			t.Fatalf("tests[%d]: Body: want %q got %q", i, test.want, se.Body)
+		}
+		if !errors.Is(err, errBadHttpStatus) {
+			t.Fatalf("tests[%d]: want errBadHttpStatus", i)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Fatalf("tests[%d]: want %q got %q", i, test.err, err)
+		}
+		for _, other := range statusErrors {
+			if other != test.err && errors.Is(err, other) {
+				t.Fatalf("tests[%d]: %q must not match %q", i, err, other)
+			}
+		}
+	}
+}
+
 func TestParseWakeup(t *testing.T) {
 	tests := []struct {
 		value  string
@@ -226,3 +493,113 @@ func TestParseWakeup(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		value  string
+		maxAge time.Duration
+	}{
+		{value: "max-age=1800", maxAge: 1800 * time.Second},
+		{value: "max-age = 60", maxAge: 60 * time.Second},
+		{value: `no-cache="Ext", MAX-AGE="120"`, maxAge: 120 * time.Second},
+		{value: "max-age=-1", maxAge: 0},
+		{value: "no-cache", maxAge: 0},
+		{value: "", maxAge: 0},
+	}
+
+	for i, test := range tests {
+		if maxAge := parseMaxAge(test.value); test.maxAge != maxAge {
+			t.Fatalf("tests[%d]: parseMaxAge(%q): want %s got %s", i, test.value, test.maxAge, maxAge)
+		}
+	}
+}
+
+func TestSameDescription(t *testing.T) {
+	dev := &Device{Location: "http://192.168.1.1:52235/dd.xml", BootId: "7", ConfigId: "42"}
+	tests := []struct {
+		dev      *Device
+		location string
+		bootId   string
+		configId string
+		same     bool
+	}{
+		{dev: dev, location: dev.Location, bootId: "7", configId: "42", same: true},
+		{dev: dev, location: dev.Location, bootId: "8", configId: "42", same: false}, // rebooted.
+		{dev: dev, location: dev.Location, bootId: "7", configId: "43", same: false}, // description changed.
+		{dev: dev, location: "http://192.168.1.2:52235/dd.xml", bootId: "7", configId: "42", same: false},
+		{dev: &Device{Location: dev.Location}, location: dev.Location, same: false}, // no CONFIGID.
+	}
+
+	for i, test := range tests {
+		service := &ssdp.Service{Location: test.location, Headers: http.Header{}}
+		if test.bootId != "" {
+			service.Headers.Set("BOOTID.UPNP.ORG", test.bootId)
+		}
+		if test.configId != "" {
+			service.Headers.Set("CONFIGID.UPNP.ORG", test.configId)
+		}
+		if same := test.dev.sameDescription(service); test.same != same {
+			t.Fatalf("tests[%d]: sameDescription(): want %t got %t", i, test.same, same)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		dev     *Device
+		expired bool
+	}{
+		{dev: &Device{MaxAge: 30 * time.Minute, LastSeen: now.Add(-10 * time.Minute)}, expired: false},
+		{dev: &Device{MaxAge: 30 * time.Minute, LastSeen: now.Add(-40 * time.Minute)}, expired: true},
+		{dev: &Device{LastSeen: now.Add(-40 * time.Minute)}, expired: false},
+		{dev: &Device{MaxAge: 30 * time.Minute}, expired: false},
+	}
+
+	for i, test := range tests {
+		if expired := test.dev.Expired(); test.expired != expired {
+			t.Fatalf("tests[%d]: Expired(): want %t got %t", i, test.expired, expired)
+		}
+	}
+}
+
+func TestSeenServices(t *testing.T) {
+	ctx := context.Background()
+	seen := newSeenServices()
+	if !seen.claim(ctx, "usn") {
+		t.Fatal("first claim: want true got false")
+	}
+	claimed := make(chan bool)
+	go func() { claimed <- seen.claim(ctx, "usn") }()
+	select {
+	case <-claimed:
+		t.Fatal("second claim: returned while the first is pending")
+	case <-time.After(50 * time.Millisecond):
+	}
+	seen.done("usn", false) // e.g. describe failed.
+	if !<-claimed {
+		t.Fatal("second claim: want true got false")
+	}
+	seen.done("usn", true)
+	if seen.claim(ctx, "usn") || seen.add("usn") {
+		t.Fatal("usn not seen after done")
+	}
+}
+
+func TestHostname(t *testing.T) {
+	tests := []struct {
+		appUrl   string
+		hostname string
+	}{
+		{"http://192.168.1.1:12345/apps", "192.168.1.1"},
+		{"http://[fd00::1]:12345/apps", "fd00::1"},
+		{"http://[fe80::1%25eth0]:12345/apps", "fe80::1%eth0"},
+	}
+
+	for i, test := range tests {
+		d := &Device{ApplicationUrl: test.appUrl}
+		if got := d.Hostname(); got != test.hostname {
+			t.Fatalf("tests[%d]: Hostname(): want %q got %q", i, test.hostname, got)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+// See license file for copyright and license details.
+
+// Package dialtest implements an in-process fake DIAL first-screen device (a
+// "TV") which advertises itself with package ssdp, serves a UPnP device
+// description and implements the DIAL application resources, so that DIAL
+// clients can be tested end to end without a real device.
+package dialtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+)
+
+const (
+	ssdpMaxAge  = 1800 * time.Second
+	httpTimeout = 5 * time.Second
+
+	descPath    = "/dd.xml"
+	appsPath    = "/apps/"
+	runPath     = "/run"
+	installPath = "/install"
+)
+
+// App is a DIAL application installed (or installable) on the Device.
+type App struct {
+	Name           string        // application name registered in the DIAL Registry.
+	AllowStop      bool          // whether the application can be stopped with DELETE.
+	AdditionalData string        // XML served in <additionalData> once running, e.g. <screenId>foo</screenId>.
+	LaunchDelay    time.Duration // time spent launching, the app is running but without additionalData.
+	Installable    bool          // if true, the app must be installed before being launched.
+	InstallDelay   time.Duration // time needed to install the app.
+
+	state      string    // stopped, launching, running, installable or installing.
+	stateSince time.Time // when state was entered.
+	dataUrl    string    // additionalDataUrl received at launch.
+}
+
+// Quirks make the Device behave like some misbehaving real devices.
+type Quirks struct {
+	// BootDelay is the time the Device takes to boot after Start(),
+	// Sleep() or SetIds(): while booting it doesn't answer M-SEARCH
+	// requests and closes HTTP connections.
+	BootDelay time.Duration
+
+	// NoWakeup omits the WAKEUP header from M-SEARCH responses.
+	NoWakeup bool
+
+	// NoNotifyWakeup omits the WAKEUP header from NOTIFY messages, as
+	// most real devices do, and from the responses to multicast M-SEARCH
+	// requests (they share the advertisement). Responses at SSDPAddr()
+	// still have it.
+	NoNotifyWakeup bool
+
+	// NotFoundApps lists application names which return 404 even if
+	// they are in Apps.
+	NotFoundApps []string
+}
+
+// Device is a fake DIAL first-screen device.
+type Device struct {
+	FriendlyName  string
+	UUID          string
+	Mac           string        // MAC advertised in the WAKEUP header.
+	WakeupTimeout time.Duration // Timeout advertised in the WAKEUP header.
+	BootId        int           // BOOTID.UPNP.ORG header value, see SetIds() to change it once started.
+	ConfigId      int           // CONFIGID.UPNP.ORG header value, see SetIds() to change it once started.
+	Quirks        Quirks
+
+	mu        sync.Mutex
+	apps      map[string]*App
+	bootUntil time.Time
+	ip        net.IP
+	httpLn    net.Listener
+	httpSrv   *http.Server
+	ssdpAddr  *net.UDPAddr
+	searches  int
+
+	bootMu   sync.Mutex // serializes boot() and Close().
+	stopBoot context.CancelFunc
+	booted   sync.WaitGroup
+}
+
+// NewDevice returns a Device (not yet started) with the given name and apps.
+func NewDevice(friendlyName string, apps ...*App) *Device {
+	d := &Device{
+		FriendlyName:  friendlyName,
+		UUID:          fmt.Sprintf("uuid:dialtest-%x", time.Now().UnixNano()),
+		Mac:           "02:00:00:00:00:01",
+		WakeupTimeout: 5 * time.Second,
+		BootId:        1,
+		ConfigId:      1,
+		apps:          make(map[string]*App),
+	}
+	for _, app := range apps {
+		d.AddApp(app)
+	}
+	return d
+}
+
+// AddApp adds (or replaces) an application on the Device.
+func (d *Device) AddApp(app *App) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	app.state = "stopped"
+	if app.Installable {
+		app.state = "installable"
+	}
+	app.stateSince = time.Now()
+	d.apps[app.Name] = app
+}
+
+// Start starts the Device HTTP server on ip (on a random port) and, once
+// booted, advertises the Device with SSDP on the interface which has ip. If
+// the SSDP multicast group can't be joined, the Device still answers unicast
+// M-SEARCH requests at SSDPAddr().
+func (d *Device) Start(ip string) error {
+	d.ip = net.ParseIP(ip)
+	if d.ip == nil {
+		return fmt.Errorf("invalid ip %q", ip)
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		return err
+	}
+	// reserve a port for unicast M-SEARCH, it's bound again at each boot.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: d.ip})
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	d.ssdpAddr = conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+
+	d.httpLn = ln
+	d.httpSrv = &http.Server{Handler: d, ReadTimeout: httpTimeout, WriteTimeout: httpTimeout}
+	go d.httpSrv.Serve(ln)
+	d.boot()
+	return nil
+}
+
+// Close stops the Device.
+func (d *Device) Close() error {
+	d.bootMu.Lock()
+	defer d.bootMu.Unlock()
+	if d.stopBoot != nil {
+		d.stopBoot()
+		d.booted.Wait()
+	}
+	return d.httpSrv.Close()
+}
+
+// Sleep makes the Device unreachable for Quirks.BootDelay, as if it was turned
+// off and then woken up.
+func (d *Device) Sleep() {
+	d.boot()
+}
+
+// SetIds changes BootId and ConfigId of a started Device and reboots it (see
+// Quirks.BootDelay), as real devices change them when they reboot or when
+// their description changes.
+func (d *Device) SetIds(bootId, configId int) {
+	d.mu.Lock()
+	d.BootId, d.ConfigId = bootId, configId
+	d.mu.Unlock()
+	d.boot()
+}
+
+// boot stops advertising the Device and starts again after Quirks.BootDelay.
+func (d *Device) boot() {
+	d.bootMu.Lock()
+	defer d.bootMu.Unlock()
+	if d.stopBoot != nil {
+		d.stopBoot()
+		d.booted.Wait()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stopBoot = cancel
+	d.mu.Lock()
+	d.bootUntil = time.Now().Add(d.Quirks.BootDelay)
+	d.mu.Unlock()
+
+	if d.Quirks.BootDelay <= 0 {
+		// answers M-SEARCH requests sent as soon as Start() returns.
+		d.advertise(ctx)
+		return
+	}
+	d.booted.Add(1)
+	go func() {
+		defer d.booted.Done()
+		t := time.NewTimer(d.Quirks.BootDelay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			d.advertise(ctx)
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// advertise starts advertising the Device until ctx is done: with
+// ssdp.Advertise() on the SSDP multicast group and with ssdp.Serve() at
+// SSDPAddr().
+func (d *Device) advertise(ctx context.Context) {
+	ads := d.advertisements()
+	notifyAds := ads
+	if d.Quirks.NoNotifyWakeup {
+		ad := *ads[0]
+		ad.Headers = ad.Headers.Clone()
+		delete(ad.Headers, "WAKEUP") // not a canonical key, Del() wouldn't find it.
+		notifyAds = []*ssdp.Advertisement{&ad}
+	}
+	d.booted.Add(1)
+	go func() {
+		defer d.booted.Done()
+		err := ssdp.Advertise(ctx, net.JoinHostPort(d.ip.String(), "0"), notifyAds)
+		if ctx.Err() == nil {
+			log.Printf("dialtest: Advertise: %s", err)
+		}
+	}()
+	conn, err := net.ListenUDP("udp4", d.ssdpAddr)
+	if err != nil {
+		log.Printf("dialtest: ListenUDP: %s", err)
+		return
+	}
+	d.booted.Add(1)
+	go func() {
+		defer d.booted.Done()
+		err := ssdp.Serve(ctx, &searchCounter{PacketConn: conn, d: d}, ads)
+		if ctx.Err() == nil {
+			log.Printf("dialtest: Serve: %s", err)
+		}
+	}()
+}
+
+func (d *Device) advertisements() []*ssdp.Advertisement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	headers := http.Header{
+		"SERVER":            {"dialtest UPnP/1.1 dialtest/1.0"},
+		"BOOTID.UPNP.ORG":   {fmt.Sprint(d.BootId)},
+		"CONFIGID.UPNP.ORG": {fmt.Sprint(d.ConfigId)},
+	}
+	if !d.Quirks.NoWakeup {
+		headers["WAKEUP"] = []string{fmt.Sprintf("MAC=%s;Timeout=%d", d.Mac, int(d.WakeupTimeout.Seconds()))}
+	}
+	return []*ssdp.Advertisement{{
+		SearchTarget:      ssdp.Dial,
+		UniqueServiceName: d.UUID + "::" + ssdp.Dial,
+		Location:          d.Location(),
+		MaxAge:            ssdpMaxAge,
+		Headers:           headers,
+	}}
+}
+
+// searchCounter counts the M-SEARCH requests read from a PacketConn.
+type searchCounter struct {
+	net.PacketConn
+	d *Device
+}
+
+func (c *searchCounter) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil && bytes.HasPrefix(b[:n], []byte("M-SEARCH ")) {
+		c.d.mu.Lock()
+		c.d.searches++
+		c.d.mu.Unlock()
+	}
+	return n, addr, err
+}
+
+// Location returns the url of the UPnP device description.
+func (d *Device) Location() string {
+	return "http://" + d.httpLn.Addr().String() + descPath
+}
+
+// ApplicationUrl returns the DIAL REST service url.
+func (d *Device) ApplicationUrl() string {
+	return "http://" + d.httpLn.Addr().String() + appsPath
+}
+
+// SSDPAddr returns the address where the Device answers unicast M-SEARCH
+// requests.
+func (d *Device) SSDPAddr() string {
+	return d.ssdpAddr.String()
+}
+
+// Searches returns the number of M-SEARCH requests received at SSDPAddr() so
+// far while not booting.
+func (d *Device) Searches() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.searches
+}
+
+// AppState returns the current state of an application: stopped, launching,
+// running, installable or installing. It returns an empty string if there is no
+// such application.
+func (d *Device) AppState(name string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	app, ok := d.apps[name]
+	if !ok {
+		return ""
+	}
+	d.advance(app)
+	return app.state
+}
+
+func (d *Device) booting() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return time.Now().Before(d.bootUntil)
+}
+
+func (d *Device) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.booting() {
+		// a booting device doesn't answer at all.
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch {
+	case r.URL.Path == descPath && r.Method == "GET":
+		d.serveDescription(w)
+	case strings.HasPrefix(r.URL.Path, appsPath):
+		d.serveApp(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *Device) serveDescription(w http.ResponseWriter) {
+	w.Header().Set("Application-URL", d.ApplicationUrl())
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:dial-multiscreen-org:device:dial:1</deviceType>
+    <friendlyName>%s</friendlyName>
+    <manufacturer>dialtest</manufacturer>
+    <modelName>Fake TV</modelName>
+    <modelNumber>1</modelNumber>
+    <UDN>%s</UDN>
+    <serviceList>
+      <service>
+        <serviceType>%s</serviceType>
+        <serviceId>urn:dial-multiscreen-org:serviceId:dial</serviceId>
+        <SCPDURL>/dial/desc.xml</SCPDURL>
+        <controlURL>/dial/control</controlURL>
+        <eventSubURL>/dial/event</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`, xmlEscape(d.FriendlyName), xmlEscape(d.UUID), ssdp.Dial)
+}
+
+func (d *Device) serveApp(w http.ResponseWriter, r *http.Request) {
+	name, instance, install := strings.TrimPrefix(r.URL.Path, appsPath), false, false
+	if n, ok := strings.CutSuffix(name, runPath); ok {
+		name, instance = n, true
+	} else if n, ok := strings.CutSuffix(name, installPath); ok {
+		name, install = n, true
+	}
+	if name == "" {
+		w.WriteHeader(http.StatusOK) // Ping().
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	app, ok := d.apps[name]
+	for _, nf := range d.Quirks.NotFoundApps {
+		ok = ok && nf != name
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	d.advance(app)
+
+	switch {
+	case install && r.Method == "GET":
+		if app.state == "installable" {
+			d.setState(app, "installing")
+			d.advance(app) // InstallDelay may be zero.
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case install:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+	case !instance && r.Method == "GET":
+		d.writeAppInfo(w, app)
+
+	case !instance && r.Method == "POST":
+		if app.state == "installable" || app.state == "installing" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 4096))
+		params, _ := url.ParseQuery(string(body))
+		app.dataUrl = params.Get("additionalDataUrl")
+		if app.state != "running" {
+			d.setState(app, "launching")
+			d.advance(app) // LaunchDelay may be zero.
+		}
+		w.Header().Set("Location", d.ApplicationUrl()+name+runPath)
+		w.WriteHeader(http.StatusCreated)
+
+	case instance && r.Method == "DELETE":
+		switch {
+		case !app.AllowStop:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case app.state != "running" && app.state != "launching":
+			http.NotFound(w, r)
+		default:
+			d.setState(app, "stopped")
+			w.WriteHeader(http.StatusOK)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// advance moves app to its next state if enough time has passed. d.mu must be
+// held.
+func (d *Device) advance(app *App) {
+	elapsed := time.Since(app.stateSince)
+	switch {
+	case app.state == "launching" && elapsed >= app.LaunchDelay:
+		d.setState(app, "running")
+		if app.dataUrl != "" && app.AdditionalData != "" {
+			go postAdditionalData(app.dataUrl, app.AdditionalData)
+		}
+	case app.state == "installing" && elapsed >= app.InstallDelay:
+		d.setState(app, "stopped")
+	}
+}
+
+func (d *Device) setState(app *App, state string) {
+	app.state = state
+	app.stateSince = time.Now()
+}
+
+func postAdditionalData(dataUrl, data string) {
+	hc := &http.Client{Timeout: httpTimeout}
+	resp, err := hc.Post(dataUrl, "text/xml; charset=utf-8", strings.NewReader(data))
+	if err != nil {
+		log.Printf("dialtest: POST additionalData: %s", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (d *Device) writeAppInfo(w http.ResponseWriter, app *App) {
+	state := app.state
+	switch state {
+	case "launching":
+		state = "running" // DIAL has no launching state.
+	case "installable", "installing":
+		state = "installable=" + d.ApplicationUrl() + app.Name + installPath
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<service xmlns="urn:dial-multiscreen-org:schemas:dial" dialVer="2.1">
+  <name>%s</name>
+  <options allowStop="%t"/>
+  <state>%s</state>
+`, xmlEscape(app.Name), app.AllowStop, xmlEscape(state))
+	if app.state == "running" || app.state == "launching" {
+		fmt.Fprintf(w, "  <link rel=\"run\" href=\"run\"/>\n")
+	}
+	if app.state == "running" && app.AdditionalData != "" {
+		fmt.Fprintf(w, "  <additionalData>%s</additionalData>\n", app.AdditionalData)
+	}
+	fmt.Fprintf(w, "</service>\n")
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
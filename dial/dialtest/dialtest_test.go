@@ -0,0 +1,232 @@
+// See license file for copyright and license details.
+
+package dialtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/dial"
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+	"github.com/MarcoLucidi01/ytcast/ssdp/ssdptest"
+)
+
+const appName = "YouTube"
+
+func startOrFatal(t *testing.T, d *Device, ip string) {
+	if err := d.Start(ip); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { d.Close() })
+}
+
+func dialDevice(t *testing.T, d *Device) *dial.Device {
+	dev := &dial.Device{
+		UniqueServiceName: d.UUID,
+		Location:          d.Location(),
+		ApplicationUrl:    d.ApplicationUrl(),
+		FriendlyName:      d.FriendlyName,
+	}
+	if err := dev.SetLocalAddr(""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return dev
+}
+
+func TestDiscover(t *testing.T) {
+	ip := ssdptest.MulticastIPOrSkip(t)
+	tests := []struct {
+		quirks Quirks
+		mac    string
+	}{
+		{Quirks{}, "02:00:00:00:00:01"},
+		{Quirks{NoWakeup: true}, ""},
+	}
+
+	for i, test := range tests {
+		d := NewDevice("Fake TV", &App{Name: appName})
+		d.Quirks = test.quirks
+		startOrFatal(t, d, ip)
+
+		ctx, cancel := context.WithTimeout(context.Background(), dial.MSearchMinTimeout+time.Second)
+		devCh, err := dial.DiscoverContext(ctx, net.JoinHostPort(ip, "0"), dial.MSearchMinTimeout)
+		if err != nil {
+			cancel()
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		var found *dial.Device
+		for dev := range devCh {
+			if dev.UniqueServiceName == d.UUID+"::"+ssdp.Dial {
+				found = dev
+				cancel()
+			}
+		}
+		cancel()
+		if found == nil {
+			t.Fatalf("tests[%d]: device not discovered", i)
+		}
+		if found.FriendlyName != d.FriendlyName {
+			t.Fatalf("tests[%d]: FriendlyName: want %q got %q", i, d.FriendlyName, found.FriendlyName)
+		}
+		if found.ApplicationUrl != d.ApplicationUrl() {
+			t.Fatalf("tests[%d]: ApplicationUrl: want %q got %q", i, d.ApplicationUrl(), found.ApplicationUrl)
+		}
+		if found.Wakeup.Mac != test.mac {
+			t.Fatalf("tests[%d]: Wakeup.Mac: want %q got %q", i, test.mac, found.Wakeup.Mac)
+		}
+	}
+}
+
+func TestLaunchAndStop(t *testing.T) {
+	d := NewDevice("Fake TV", &App{
+		Name:           appName,
+		AllowStop:      true,
+		AdditionalData: "<screenId>screen123</screenId>",
+		LaunchDelay:    200 * time.Millisecond,
+	})
+	startOrFatal(t, d, "127.0.0.1")
+	dev := dialDevice(t, d)
+
+	app, err := dev.GetAppInfo(appName, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if app.State != "stopped" {
+		t.Fatalf("State: want %q got %q", "stopped", app.State)
+	}
+
+	cb, err := dial.NewCallbackServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cb.Close()
+	res, err := dev.LaunchWithOptions(appName, "", "", dial.LaunchOptions{Callback: cb})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// launching: running, but no additionalData yet.
+	if app, err = dev.GetAppInfo(appName, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if app.State != "running" || app.Additional.Data != "" {
+		t.Fatalf("launching: want running without additionalData got %q %q", app.State, app.Additional.Data)
+	}
+	if d.AppState(appName) != "launching" {
+		t.Fatalf("AppState: want %q got %q", "launching", d.AppState(appName))
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if app, err = dev.GetAppInfo(appName, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if app.Additional.Data != "<screenId>screen123</screenId>" {
+		t.Fatalf("Additional.Data: want screenId got %q", app.Additional.Data)
+	}
+	select {
+	case data := <-res.AdditionalData:
+		if data != "<screenId>screen123</screenId>" {
+			t.Fatalf("res.AdditionalData: want screenId got %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("res.AdditionalData: timeout")
+	}
+
+	if err := dev.Stop(appName, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.AppState(appName) != "stopped" {
+		t.Fatalf("AppState: want %q got %q", "stopped", d.AppState(appName))
+	}
+	if err := dev.Stop(appName, ""); !errors.Is(err, dial.ErrNotRunning) {
+		t.Fatalf("Stop: want %q got %v", dial.ErrNotRunning, err)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	d := NewDevice("Fake TV", &App{Name: appName, Installable: true, InstallDelay: 100 * time.Millisecond})
+	startOrFatal(t, d, "127.0.0.1")
+	dev := dialDevice(t, d)
+
+	app, err := dev.GetAppInfo(appName, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if app.InstallUrl() == "" {
+		t.Fatalf("State: want installable got %q", app.State)
+	}
+	if err := dev.Install(app.InstallUrl(), ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if app, err = dev.GetAppInfo(appName, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if app.State != "stopped" {
+		t.Fatalf("State: want %q got %q", "stopped", app.State)
+	}
+}
+
+func TestQuirks(t *testing.T) {
+	d := NewDevice("Fake TV", &App{Name: appName}, &App{Name: "Netflix"})
+	d.Quirks = Quirks{BootDelay: 500 * time.Millisecond, NotFoundApps: []string{"Netflix"}}
+	startOrFatal(t, d, "127.0.0.1")
+	dev := dialDevice(t, d)
+
+	if dev.Ping() {
+		t.Fatal("Ping: booting device must not answer")
+	}
+	time.Sleep(600 * time.Millisecond)
+	if !dev.Ping() {
+		t.Fatal("Ping: booted device must answer")
+	}
+	if _, err := dev.GetAppInfo(appName, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := dev.GetAppInfo("Netflix", ""); err == nil {
+		t.Fatal("GetAppInfo: was expecting error but got nil")
+	}
+}
+
+func TestTryWakeup(t *testing.T) {
+	d := NewDevice("Fake TV", &App{Name: appName})
+	d.Quirks.NoWakeup = true // the WAKEUP header values already known must be kept.
+	startOrFatal(t, d, "127.0.0.1")
+	dev := dialDevice(t, d)
+	dev.UniqueServiceName = d.UUID + "::" + ssdp.Dial
+	// the device woke up on another port, it must be discovered again.
+	dev.Location, dev.ApplicationUrl = "http://127.0.0.1:1/dd.xml", "http://127.0.0.1:1/apps/"
+	dev.Wakeup = dial.Wakeup{Mac: d.Mac, Timeout: d.WakeupTimeout}
+	dev.SearchHost = d.SSDPAddr()
+
+	// magic packets are sent to a loopback listener rather than broadcasted.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	opts := dial.WakeOptions{Addrs: []net.IP{net.IPv4(127, 0, 0, 1)}, Ports: []int{conn.LocalAddr().(*net.UDPAddr).Port}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := dev.TryWakeupWithOptionsContext(ctx, opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadFrom(make([]byte, 256)); err != nil {
+		t.Fatalf("magic packet: unexpected error: %s", err)
+	}
+	if dev.ApplicationUrl != d.ApplicationUrl() {
+		t.Fatalf("ApplicationUrl: want %q got %q", d.ApplicationUrl(), dev.ApplicationUrl)
+	}
+	if bootId := fmt.Sprint(d.BootId); dev.BootId != bootId {
+		t.Fatalf("BootId: want %q got %q", bootId, dev.BootId)
+	}
+	if dev.Wakeup.Mac != d.Mac {
+		t.Fatalf("Wakeup.Mac: want %q got %q", d.Mac, dev.Wakeup.Mac)
+	}
+}
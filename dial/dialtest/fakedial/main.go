@@ -0,0 +1,44 @@
+// See license file for copyright and license details.
+
+// fakedial runs a fake DIAL device (see package dialtest) until interrupted,
+// useful to try ytcast DIAL flow without a real TV:
+//
+//	$ go run ./dial/dialtest/fakedial -ip 192.168.1.10 &
+//	$ ytcast -s
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/MarcoLucidi01/ytcast/dial/dialtest"
+)
+
+var (
+	flagName      = flag.String("name", "Fake TV", "device friendly name")
+	flagIP        = flag.String("ip", "127.0.0.1", "ip address to listen on (SSDP multicast needs a multicast capable interface)")
+	flagScreenId  = flag.String("screenid", "fakedial-screen", "screenId returned in YouTube additionalData")
+	flagBootDelay = flag.Duration("boot", 0, "boot delay (slow boot quirk)")
+	flagNoWakeup  = flag.Bool("nowakeup", false, "omit WAKEUP header (missing WAKEUP quirk)")
+)
+
+func main() {
+	flag.Parse()
+	d := dialtest.NewDevice(*flagName,
+		&dialtest.App{Name: "YouTube", AllowStop: true, AdditionalData: fmt.Sprintf("<screenId>%s</screenId>", *flagScreenId)},
+		&dialtest.App{Name: "Netflix", AllowStop: true},
+	)
+	d.Quirks = dialtest.Quirks{BootDelay: *flagBootDelay, NoWakeup: *flagNoWakeup}
+	if err := d.Start(*flagIP); err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+	log.Printf("%q %s\nLOCATION %s\nSSDP %s", d.FriendlyName, d.UUID, d.Location(), d.SSDPAddr())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+}
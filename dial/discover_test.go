@@ -0,0 +1,296 @@
+// See license file for copyright and license details.
+
+package dial_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/dial"
+	"github.com/MarcoLucidi01/ytcast/dial/dialtest"
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+	"github.com/MarcoLucidi01/ytcast/ssdp/ssdptest"
+)
+
+const appName = "YouTube"
+
+// startDevice starts d on ip (the test is skipped if it can't, e.g.
+// 127.0.0.0/8 is not all loopback everywhere) and returns the dial.Device
+// which describes it, as discovered.
+func startDevice(t *testing.T, d *dialtest.Device, ip string) *dial.Device {
+	if err := d.Start(ip); err != nil {
+		t.Skipf("Start: %s", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	dev := &dial.Device{
+		UniqueServiceName: d.UUID + "::" + ssdp.Dial,
+		Location:          d.Location(),
+		ApplicationUrl:    d.ApplicationUrl(),
+		FriendlyName:      d.FriendlyName,
+	}
+	if err := dev.SetLocalAddr(""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return dev
+}
+
+func TestDiscoverKnown(t *testing.T) {
+	ip := ssdptest.MulticastIPOrSkip(t)
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	known := startDevice(t, d, ip)
+	known.FriendlyName = "Known TV" // kept only if the description is not fetched again.
+	known.BootId = "1"
+	known.ConfigId = "1"
+
+	tests := []struct {
+		bootId       int
+		configId     int
+		friendlyName string
+	}{
+		{1, 1, known.FriendlyName},
+		{2, 1, d.FriendlyName}, // rebooted.
+		{1, 2, d.FriendlyName}, // description changed.
+	}
+
+	for i, test := range tests {
+		d.SetIds(test.bootId, test.configId)
+
+		ctx, cancel := context.WithTimeout(context.Background(), dial.MSearchMinTimeout+time.Second)
+		opts := dial.DiscoverOptions{Known: []*dial.Device{known}}
+		devCh, err := dial.DiscoverWithOptionsContext(ctx, net.JoinHostPort(ip, "0"), dial.MSearchMinTimeout, opts)
+		if err != nil {
+			cancel()
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		var found *dial.Device
+		for dev := range devCh {
+			if dev.UniqueServiceName == known.UniqueServiceName {
+				found = dev
+				cancel()
+			}
+		}
+		cancel()
+		if found == nil {
+			t.Fatalf("tests[%d]: device not discovered", i)
+		}
+		if found.FriendlyName != test.friendlyName {
+			t.Fatalf("tests[%d]: FriendlyName: want %q got %q", i, test.friendlyName, found.FriendlyName)
+		}
+		if bootId := fmt.Sprint(test.bootId); found.BootId != bootId {
+			t.Fatalf("tests[%d]: BootId: want %q got %q", i, bootId, found.BootId)
+		}
+		if found.MaxAge != 1800*time.Second {
+			t.Fatalf("tests[%d]: MaxAge: want %s got %s", i, 1800*time.Second, found.MaxAge)
+		}
+	}
+}
+
+func TestListenKnown(t *testing.T) {
+	ip := ssdptest.MulticastIPOrSkip(t)
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	known := startDevice(t, d, ip)
+	known.FriendlyName = "Known TV" // kept only if the description is not fetched again.
+	known.BootId = "1"
+	known.ConfigId = "1"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := dial.ListenOptions{Known: []*dial.Device{known}}
+	notifyCh, err := dial.ListenWithOptions(ctx, net.JoinHostPort(ip, "0"), opts)
+	if err != nil {
+		t.Skipf("Listen: %s", err)
+	}
+
+	tests := []struct {
+		bootId       int
+		configId     int
+		friendlyName string
+	}{
+		{1, 1, known.FriendlyName},
+		{2, 1, d.FriendlyName}, // rebooted.
+		{1, 2, d.FriendlyName}, // description changed.
+	}
+
+	for i, test := range tests {
+		d.SetIds(test.bootId, test.configId) // says ssdp:byebye and ssdp:alive again.
+
+		// skips the announcements of the previous boots.
+		bootId, configId := fmt.Sprint(test.bootId), fmt.Sprint(test.configId)
+		var found *dial.Device
+		timeout := time.After(5 * time.Second)
+		for found == nil {
+			select {
+			case n := <-notifyCh:
+				if n.UniqueServiceName == known.UniqueServiceName && n.Type == dial.NotifyAlive &&
+					n.Device.BootId == bootId && n.Device.ConfigId == configId {
+					found = n.Device
+				}
+			case <-timeout:
+				t.Fatalf("tests[%d]: ssdp:alive not received", i)
+			}
+		}
+		if found.FriendlyName != test.friendlyName {
+			t.Fatalf("tests[%d]: FriendlyName: want %q got %q", i, test.friendlyName, found.FriendlyName)
+		}
+	}
+}
+
+func TestListenRefresh(t *testing.T) {
+	ip := ssdptest.MulticastIPOrSkip(t)
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	d.Quirks.NoNotifyWakeup = true
+	startDevice(t, d, ip)
+
+	// discovered at SSDPAddr(), where the WAKEUP header is sent.
+	devCh, err := dial.DiscoverHostsContext(context.Background(), "", []string{d.SSDPAddr()}, dial.MSearchMinTimeout)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var cached *dial.Device
+	for dev := range devCh {
+		cached = dev
+	}
+	if cached == nil || cached.Wakeup.Mac != d.Mac {
+		t.Fatalf("device not discovered with Wakeup.Mac %q", d.Mac)
+	}
+	cached.Icons = []dial.Icon{{Url: "http://" + ip + "/icon.png", Path: "/tmp/icon.png"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifyCh, err := dial.ListenWithOptions(ctx, net.JoinHostPort(ip, "0"), dial.ListenOptions{Known: []*dial.Device{cached}})
+	if err != nil {
+		t.Skipf("Listen: %s", err)
+	}
+	d.SetIds(2, 1) // rebooted: the description is fetched again.
+
+	var found *dial.Device
+	timeout := time.After(5 * time.Second)
+	for found == nil {
+		select {
+		case n := <-notifyCh:
+			if n.UniqueServiceName == cached.UniqueServiceName && n.Type == dial.NotifyAlive && n.Device.BootId == "2" {
+				found = n.Device
+			}
+		case <-timeout:
+			t.Fatalf("ssdp:alive not received")
+		}
+	}
+	if found.Wakeup.Mac != "" {
+		t.Fatalf("Wakeup.Mac: want %q got %q", "", found.Wakeup.Mac)
+	}
+	found.Icons = []dial.Icon{{Url: "http://" + ip + "/icon.png"}} // the fake device has none.
+
+	cached.Refresh(found)
+	if cached.Wakeup.Mac != d.Mac {
+		t.Fatalf("Wakeup.Mac: want %q got %q", d.Mac, cached.Wakeup.Mac)
+	}
+	if cached.SearchHost != d.SSDPAddr() {
+		t.Fatalf("SearchHost: want %q got %q", d.SSDPAddr(), cached.SearchHost)
+	}
+	if cached.BootId != "2" {
+		t.Fatalf("BootId: want %q got %q", "2", cached.BootId)
+	}
+	if cached.Icons[0].Path != "/tmp/icon.png" {
+		t.Fatalf("Icons[0].Path: want %q got %q", "/tmp/icon.png", cached.Icons[0].Path)
+	}
+}
+
+func TestDiscoverHosts(t *testing.T) {
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	want := startDevice(t, d, "127.0.0.1")
+
+	tests := []struct {
+		hosts      []string
+		searchHost string
+		mac        string
+		searches   int
+	}{
+		{[]string{d.SSDPAddr()}, d.SSDPAddr(), d.Mac, 3}, // one M-SEARCH per round.
+		{[]string{d.Location()}, d.Location(), "", 0},    // no WAKEUP without M-SEARCH.
+	}
+
+	for i, test := range tests {
+		searches := d.Searches()
+		devCh, err := dial.DiscoverHostsContext(context.Background(), "", test.hosts, dial.MSearchMinTimeout)
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		var devs []*dial.Device
+		for dev := range devCh {
+			devs = append(devs, dev)
+		}
+		if len(devs) != 1 {
+			t.Fatalf("tests[%d]: want 1 device got %d", i, len(devs))
+		}
+		if devs[0].UniqueServiceName != want.UniqueServiceName {
+			t.Fatalf("tests[%d]: UniqueServiceName: want %q got %q", i, want.UniqueServiceName, devs[0].UniqueServiceName)
+		}
+		if devs[0].ApplicationUrl != want.ApplicationUrl {
+			t.Fatalf("tests[%d]: ApplicationUrl: want %q got %q", i, want.ApplicationUrl, devs[0].ApplicationUrl)
+		}
+		if devs[0].SearchHost != test.searchHost {
+			t.Fatalf("tests[%d]: SearchHost: want %q got %q", i, test.searchHost, devs[0].SearchHost)
+		}
+		if devs[0].Wakeup.Mac != test.mac {
+			t.Fatalf("tests[%d]: Wakeup.Mac: want %q got %q", i, test.mac, devs[0].Wakeup.Mac)
+		}
+		if n := d.Searches() - searches; n != test.searches {
+			t.Fatalf("tests[%d]: Searches: want %d got %d", i, test.searches, n)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	want := startDevice(t, d, "127.0.0.2")
+	u, err := url.Parse(d.Location())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		probes []dial.ScanProbe
+		found  bool
+	}{
+		{[]dial.ScanProbe{{Port: port, Path: "/nope.xml"}, {Port: port, Path: u.Path}}, true},
+		{[]dial.ScanProbe{{Port: port, Path: "/nope.xml"}}, false},
+	}
+
+	for i, test := range tests {
+		devCh, err := dial.Scan(context.Background(), "127.0.0.1:0", dial.ScanOptions{Probes: test.probes})
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		var devs []*dial.Device
+		for dev := range devCh {
+			devs = append(devs, dev)
+		}
+		if !test.found {
+			if len(devs) != 0 {
+				t.Fatalf("tests[%d]: want 0 devices got %d", i, len(devs))
+			}
+			continue
+		}
+		if len(devs) != 1 {
+			t.Fatalf("tests[%d]: want 1 device got %d", i, len(devs))
+		}
+		if devs[0].UniqueServiceName != want.UniqueServiceName {
+			t.Fatalf("tests[%d]: UniqueServiceName: want %q got %q", i, want.UniqueServiceName, devs[0].UniqueServiceName)
+		}
+		if devs[0].ApplicationUrl != want.ApplicationUrl {
+			t.Fatalf("tests[%d]: ApplicationUrl: want %q got %q", i, want.ApplicationUrl, devs[0].ApplicationUrl)
+		}
+		if devs[0].SearchHost != d.Location() {
+			t.Fatalf("tests[%d]: SearchHost: want %q got %q", i, d.Location(), devs[0].SearchHost)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// See license file for copyright and license details.
+
+// This file implements a presence monitor for a set of Devices, combining
+// periodic pings, SSDP NOTIFY messages and re-discovery.
+
+package dial
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	monitorPingInterval     = 30 * time.Second
+	monitorDiscoverInterval = 2 * time.Minute
+	monitorMaxPingFailures  = 2
+)
+
+// Event types.
+const (
+	EventOnline         = "online"          // the Device is up.
+	EventOffline        = "offline"         // the Device is down (or it said ssdp:byebye).
+	EventAddressChanged = "address-changed" // the Device changed ip address and/or service ports.
+)
+
+// Event is a presence change of a Device watched by a Monitor.
+type Event struct {
+	Type           string    // EventOnline, EventOffline or EventAddressChanged.
+	Time           time.Time // when the change was detected.
+	Device         *Device   // the Device, updated for EventAddressChanged.
+	PreviousAppUrl string    // ApplicationUrl before the change (EventAddressChanged only).
+}
+
+// Monitor watches the presence of a set of Devices: they are pinged
+// periodically, SSDP NOTIFY messages are listened for and offline Devices are
+// periodically re-discovered (also to detect address changes).
+type Monitor struct {
+	PingInterval     time.Duration // how often Devices are pinged (default 30s).
+	DiscoverInterval time.Duration // how often offline Devices are re-discovered (default 2m).
+
+	localAddr string
+	devices   []*Device
+}
+
+// watched is the presence state of a Device watched by a Monitor.
+type watched struct {
+	dev      *Device
+	known    bool // false until the first presence check.
+	online   bool
+	failures int  // consecutive failed pings.
+	pinging  bool // a ping is in progress.
+}
+
+// presence is a presence check result: dev is not nil if it comes from a
+// NOTIFY message (but ssdp:byebye) or from discovery.
+type presence struct {
+	usn  string
+	dev  *Device
+	up   bool
+	ping bool // true if it comes from a ping.
+}
+
+// NewMonitor returns a Monitor for devices which uses localAddr for network
+// operations. devices are copied, Events carry the Monitor's copies.
+func NewMonitor(localAddr string, devices ...*Device) *Monitor {
+	m := &Monitor{localAddr: localAddr}
+	for _, dev := range devices {
+		m.devices = append(m.devices, dev.clone())
+	}
+	return m
+}
+
+// Watch starts watching the Devices and returns the channel where Events are
+// sent until ctx is done. The initial state of each Device is reported as
+// EventOnline or EventOffline.
+func (m *Monitor) Watch(ctx context.Context) chan *Event {
+	notifyCh, err := ListenWithOptions(ctx, m.localAddr, ListenOptions{Known: m.devices})
+	if err != nil {
+		log.Printf("Listen: %s", err) // pings and discovery still work.
+	}
+	ch := make(chan *Event)
+	go m.run(ctx, notifyCh, ch)
+	return ch
+}
+
+func (m *Monitor) run(ctx context.Context, notifyCh chan *Notification, ch chan *Event) {
+	defer close(ch)
+
+	pingInterval := m.PingInterval
+	if pingInterval <= 0 {
+		pingInterval = monitorPingInterval
+	}
+	discoverInterval := m.DiscoverInterval
+	if discoverInterval <= 0 {
+		discoverInterval = monitorDiscoverInterval
+	}
+
+	watching := make(map[string]*watched)
+	for _, dev := range m.devices {
+		watching[dev.UniqueServiceName] = &watched{dev: dev}
+	}
+	results := make(chan *presence)
+	discovering := false
+	discoverDone := make(chan struct{})
+
+	ping := func() {
+		for usn, w := range watching {
+			if w.pinging {
+				continue
+			}
+			w.pinging = true
+			go func(dev *Device) {
+				up := dev.PingContext(ctx)
+				select {
+				case results <- &presence{usn: usn, up: up, ping: true}:
+				case <-ctx.Done():
+				}
+			}(w.dev)
+		}
+	}
+	discover := func() {
+		var searchHosts []string
+		offline := false
+		for _, w := range watching {
+			if w.known && !w.online {
+				offline = true
+				if w.dev.SearchHost != "" {
+					searchHosts = append(searchHosts, w.dev.SearchHost)
+				}
+			}
+		}
+		if !offline || discovering {
+			return
+		}
+		discovering = true
+		go func() {
+			defer func() {
+				select {
+				case discoverDone <- struct{}{}:
+				case <-ctx.Done():
+				}
+			}()
+			m.discover(ctx, searchHosts, results)
+		}()
+	}
+
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+	discoverTicker := time.NewTicker(discoverInterval)
+	defer discoverTicker.Stop()
+	ping()
+
+	for {
+		var p *presence
+		select {
+		case <-ctx.Done():
+			return
+		case <-pingTicker.C:
+			ping()
+			continue
+		case <-discoverTicker.C:
+			discover()
+			continue
+		case <-discoverDone:
+			discovering = false
+			continue
+		case p = <-results:
+		case n, ok := <-notifyCh:
+			if !ok {
+				notifyCh = nil // receiving from a nil channel never succeeds.
+				continue
+			}
+			p = &presence{usn: n.UniqueServiceName, dev: n.Device, up: n.Type != NotifyByebye}
+		}
+
+		w, ok := watching[p.usn]
+		if !ok {
+			continue
+		}
+		if p.ping {
+			w.pinging = false
+			if !p.up {
+				// a single lost request doesn't mean the Device
+				// went offline.
+				w.failures++
+				if w.known && w.online && w.failures < monitorMaxPingFailures {
+					continue
+				}
+			}
+		}
+		for _, ev := range w.update(p) {
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// update updates the state of w with p and returns the resulting Events.
+func (w *watched) update(p *presence) []*Event {
+	now := time.Now()
+	var events []*Event
+	if p.dev != nil {
+		// w.dev may still be pinged, it's replaced rather than
+		// modified. what p.dev lacks is kept, e.g. Wakeup (usually
+		// missing from NOTIFY messages) and SearchHost.
+		dev := w.dev.clone()
+		dev.Refresh(p.dev)
+		if dev.ApplicationUrl != w.dev.ApplicationUrl {
+			events = append(events, &Event{Type: EventAddressChanged, Time: now, Device: dev, PreviousAppUrl: w.dev.ApplicationUrl})
+		}
+		w.dev = dev
+	}
+	if p.up {
+		w.failures = 0
+	}
+	if !w.known || w.online != p.up {
+		typ := EventOffline
+		if p.up {
+			typ = EventOnline
+		}
+		events = append(events, &Event{Type: typ, Time: now, Device: w.dev})
+	}
+	w.known = true
+	w.online = p.up
+	return events
+}
+
+// discover re-discovers Devices with multicast and at searchHosts, sending
+// them to results.
+func (m *Monitor) discover(ctx context.Context, searchHosts []string, results chan *presence) {
+	devCh, err := DiscoverContext(ctx, m.localAddr, MSearchMinTimeout)
+	if err != nil {
+		log.Printf("Discover: %s", err)
+		devCh = nil
+	}
+	var hostsCh chan *Device
+	if len(searchHosts) > 0 {
+		if hostsCh, err = DiscoverHostsContext(ctx, m.localAddr, searchHosts, MSearchMinTimeout); err != nil {
+			log.Printf("DiscoverHosts: %s", err)
+			hostsCh = nil
+		}
+	}
+	for devCh != nil || hostsCh != nil {
+		var dev *Device
+		var ok bool
+		select {
+		case dev, ok = <-devCh:
+			if !ok {
+				devCh = nil
+				continue
+			}
+		case dev, ok = <-hostsCh:
+			if !ok {
+				hostsCh = nil
+				continue
+			}
+		}
+		select {
+		case results <- &presence{usn: dev.UniqueServiceName, dev: dev, up: true}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
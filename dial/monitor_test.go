@@ -0,0 +1,67 @@
+// See license file for copyright and license details.
+
+package dial_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/dial"
+	"github.com/MarcoLucidi01/ytcast/dial/dialtest"
+)
+
+func TestMonitor(t *testing.T) {
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	d.Quirks.NoNotifyWakeup = true
+	dev := startDevice(t, d, "127.0.0.1")
+	// the same device after a restart on a different port, it boots (and
+	// advertises itself) once d is closed.
+	moved := dialtest.NewDevice("Fake TV", &dialtest.App{Name: appName})
+	moved.UUID = d.UUID
+	moved.Quirks.BootDelay = time.Second
+	moved.Quirks.NoWakeup = true // the Wakeup values already known must be kept.
+	startDevice(t, moved, "127.0.0.1")
+	dev.SearchHost = moved.Location()
+	dev.Wakeup = dial.Wakeup{Mac: "02:00:00:00:00:99", Timeout: time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	m := dial.NewMonitor("", dev)
+	m.PingInterval = 50 * time.Millisecond
+	m.DiscoverInterval = 200 * time.Millisecond
+	evCh := m.Watch(ctx)
+
+	tests := []struct {
+		event   string
+		appUrl  string
+		prevUrl string
+	}{
+		{dial.EventOnline, d.ApplicationUrl(), ""},
+		{dial.EventOffline, d.ApplicationUrl(), ""},
+		{dial.EventAddressChanged, moved.ApplicationUrl(), d.ApplicationUrl()},
+		{dial.EventOnline, moved.ApplicationUrl(), ""},
+	}
+
+	for i, test := range tests {
+		if i == 1 {
+			d.Close()
+		}
+		ev, ok := <-evCh
+		if !ok {
+			t.Fatalf("tests[%d]: channel closed: %s", i, ctx.Err())
+		}
+		if ev.Type != test.event {
+			t.Fatalf("tests[%d]: Type: want %q got %q", i, test.event, ev.Type)
+		}
+		if ev.Device.ApplicationUrl != test.appUrl {
+			t.Fatalf("tests[%d]: ApplicationUrl: want %q got %q", i, test.appUrl, ev.Device.ApplicationUrl)
+		}
+		if ev.PreviousAppUrl != test.prevUrl {
+			t.Fatalf("tests[%d]: PreviousAppUrl: want %q got %q", i, test.prevUrl, ev.PreviousAppUrl)
+		}
+		if ev.Device.Wakeup != dev.Wakeup {
+			t.Fatalf("tests[%d]: Wakeup: want %+v got %+v", i, dev.Wakeup, ev.Device.Wakeup)
+		}
+	}
+}
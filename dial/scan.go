@@ -0,0 +1,279 @@
+// See license file for copyright and license details.
+
+// This file implements a discovery fallback for networks where multicast is
+// filtered (e.g. guest networks or some mesh routers): the local subnet is
+// swept probing the usual DIAL description ports and paths.
+
+package dial
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+)
+
+const (
+	scanConcurrency = 64
+	scanTimeout     = 1 * time.Second
+	scanMaxPrefix   = 22 // larger subnets are reduced to the /24 of the local address.
+	scanMaxDescSize = 256 * 1024
+)
+
+var errNoScanNet = errors.New("no IPv4 network to scan")
+
+// ScanProbe is a port and path where DIAL devices usually serve their UPnP
+// device description.
+type ScanProbe struct {
+	Port int
+	Path string
+}
+
+// ScanProbes are the default probes tried on each host by Scan().
+var ScanProbes = []ScanProbe{
+	{8008, "/ssdp/device-desc.xml"}, // Chromecast and Android TV.
+	{8060, "/dial/dd.xml"},          // Roku.
+	{7678, "/nservice/"},            // Samsung.
+	{36866, "/"},                    // LG (webOS).
+}
+
+// ScanOptions tunes the subnet scan. The zero value uses the defaults.
+type ScanOptions struct {
+	Probes      []ScanProbe   // ports and paths probed on each host (default ScanProbes).
+	Concurrency int           // hosts probed at the same time (default 64).
+	Timeout     time.Duration // timeout of each probe (default 1s).
+}
+
+// withDefaults returns a copy of opts with the unset values replaced by the
+// defaults.
+func (opts ScanOptions) withDefaults() ScanOptions {
+	if len(opts.Probes) == 0 {
+		opts.Probes = ScanProbes
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = scanConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = scanTimeout
+	}
+	return opts
+}
+
+// Scan discovers (unique) DIAL server devices sweeping the local IPv4 subnet
+// of the interface which has localAddr's ip (or of every up interface if
+// localAddr is empty): each host is probed with an HTTP GET request to the
+// usual description ports and paths and the ones responding with an
+// Application-URL header become Devices. It's much slower than Discover(),
+// it's meant for networks where multicast is filtered.
+// Each Device records its description url in SearchHost, so that it can be
+// found again with DiscoverHosts().
+// The scan stops when ctx is done.
+func Scan(ctx context.Context, localAddr string, opts ScanOptions) (chan *Device, error) {
+	opts = opts.withDefaults()
+	hosts, err := scanHosts(localAddr)
+	if err != nil {
+		return nil, err
+	}
+	hc, err := newHTTPClient(localAddr)
+	if err != nil {
+		return nil, err
+	}
+	hc.Timeout = opts.Timeout
+	log.Printf("scanning %d hosts, %d probes each", len(hosts), len(opts.Probes))
+
+	hostCh := make(chan net.IP)
+	devCh := make(chan *Device)
+	seen := newSeenServices()
+	var probed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < min(opts.Concurrency, len(hosts)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range hostCh {
+				probed.Add(1)
+				dev := probeHost(ctx, hc, host, opts.Probes)
+				if dev == nil || !seen.add(dev.UniqueServiceName) {
+					continue
+				}
+				if err := dev.SetLocalAddr(localAddr); err != nil {
+					log.Printf("%s: SetLocalAddr: %s", dev.FriendlyName, err)
+					continue
+				}
+				log.Printf("scanned DIAL device %q at %s", dev.FriendlyName, dev.Location)
+				select {
+				case devCh <- dev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(devCh)
+		defer wg.Wait()
+		defer close(hostCh)
+		for _, host := range hosts {
+			select {
+			case hostCh <- host:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		// logs a summary when the scan is over.
+		start := time.Now()
+		wg.Wait()
+		log.Printf("scanned %d/%d hosts in %s", probed.Load(), len(hosts), time.Since(start).Round(time.Millisecond))
+	}()
+
+	return devCh, nil
+}
+
+// probeHost tries probes on host and returns the Device found with the first
+// one (in order) which responds with an Application-URL header, or nil. Probes
+// run at the same time and none is skipped if others time out: a device may
+// drop packets on some ports and serve DIAL on others.
+func probeHost(ctx context.Context, hc *http.Client, host net.IP, probes []ScanProbe) *Device {
+	devs := make([]*Device, len(probes))
+	var wg sync.WaitGroup
+	for i, probe := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			location := "http://" + net.JoinHostPort(host.String(), strconv.Itoa(probe.Port)) + probe.Path
+			dev, err := probeDescription(ctx, hc, location)
+			if err != nil {
+				return // e.g. connection refused or timeout.
+			}
+			devs[i] = dev
+		}()
+	}
+	wg.Wait()
+	for _, dev := range devs {
+		if dev != nil {
+			return dev
+		}
+	}
+	return nil
+}
+
+// probeDescription fetches the description at location and parses it as a
+// DIAL Device. The USN comes from the UDN in the description, as in
+// DiscoverHosts() with a LOCATION.
+func probeDescription(ctx context.Context, hc *http.Client, location string) (*Device, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Application-URL") == "" {
+		return nil, errNoAppUrl
+	}
+	desc, err := io.ReadAll(io.LimitReader(resp.Body, scanMaxDescSize))
+	if err != nil {
+		return nil, err
+	}
+	service := &ssdp.Service{Location: location, SearchTarget: dialSearchTarget, Headers: http.Header{}}
+	dev, err := parseDevice(service, desc, resp.Header)
+	if err != nil {
+		return nil, err
+	}
+	if dev.UniqueDeviceName == "" {
+		return nil, errNoUDN
+	}
+	dev.UniqueServiceName = dev.UniqueDeviceName + "::" + dialSearchTarget
+	dev.SearchHost = location
+	return dev, nil
+}
+
+// scanHosts returns the ips of the hosts of the IPv4 networks of the interface
+// which has localAddr's ip, or of every up and non-loopback interface if
+// localAddr is empty, excluding the local ips.
+func scanHosts(localAddr string) ([]net.IP, error) {
+	var ifaces []net.Interface
+	if localAddr != "" {
+		host, _, err := net.SplitHostPort(localAddr)
+		if err != nil {
+			host = localAddr
+		}
+		ifi, err := ssdp.InterfaceByIP(net.ParseIP(host))
+		if err != nil {
+			return nil, err
+		}
+		ifaces = append(ifaces, *ifi)
+	} else {
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			return nil, err
+		}
+	}
+	var hosts []net.IP
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || (localAddr == "" && ifi.Flags&net.FlagLoopback != 0) {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if a, ok := addr.(*net.IPNet); ok {
+				hosts = append(hosts, subnetHosts(a)...)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errNoScanNet
+	}
+	return hosts, nil
+}
+
+// subnetHosts returns the ips of the hosts of the IPv4 network n (but n.IP),
+// without network and broadcast addresses. Networks larger than /22 are
+// reduced to the /24 of n.IP. It returns nil if n is not IPv4 or doesn't have
+// hosts other than n.IP (/31 and /32).
+func subnetHosts(n *net.IPNet) []net.IP {
+	ip, mask := n.IP.To4(), n.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:] // IPv4 mask in 16-byte form.
+	}
+	if ip == nil || len(mask) != net.IPv4len {
+		return nil
+	}
+	ones, _ := mask.Size()
+	if ones > 30 {
+		return nil
+	}
+	if ones < scanMaxPrefix {
+		log.Printf("%s: subnet too large, scanning %s/24 only", n, ip.Mask(net.CIDRMask(24, 32)))
+		ones, mask = 24, net.CIDRMask(24, 32)
+	}
+	local := binary.BigEndian.Uint32(ip)
+	network := binary.BigEndian.Uint32(ip.Mask(mask))
+	broadcast := network | (1<<(32-ones) - 1)
+	var hosts []net.IP
+	for u := network + 1; u < broadcast; u++ {
+		if u == local {
+			continue
+		}
+		hosts = append(hosts, binary.BigEndian.AppendUint32(nil, u))
+	}
+	return hosts
+}
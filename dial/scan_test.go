@@ -0,0 +1,100 @@
+// See license file for copyright and license details.
+
+package dial
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		cidr  string
+		n     int
+		first string
+		last  string
+	}{
+		{"192.168.1.10/24", 253, "192.168.1.1", "192.168.1.254"},
+		{"192.168.1.1/24", 253, "192.168.1.2", "192.168.1.254"},
+		{"10.0.0.5/30", 1, "10.0.0.6", "10.0.0.6"},
+		{"10.0.0.5/22", 1021, "10.0.0.1", "10.0.3.254"},
+		{"10.1.2.3/16", 253, "10.1.2.1", "10.1.2.254"}, // reduced to /24.
+		{"10.0.0.5/31", 0, "", ""},
+		{"10.0.0.5/32", 0, "", ""},
+		{"fd00::1/64", 0, "", ""},
+	}
+
+	for i, test := range tests {
+		ip, n, err := net.ParseCIDR(test.cidr)
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		n.IP = ip
+		hosts := subnetHosts(n)
+		if len(hosts) != test.n {
+			t.Fatalf("tests[%d]: len(hosts): want %d got %d", i, test.n, len(hosts))
+		}
+		if test.n == 0 {
+			continue
+		}
+		if first := hosts[0].String(); first != test.first {
+			t.Fatalf("tests[%d]: first: want %s got %s", i, test.first, first)
+		}
+		if last := hosts[len(hosts)-1].String(); last != test.last {
+			t.Fatalf("tests[%d]: last: want %s got %s", i, test.last, last)
+		}
+		for _, h := range hosts {
+			if h.Equal(ip) {
+				t.Fatalf("tests[%d]: local ip %s in hosts", i, ip)
+			}
+		}
+	}
+}
+
+func TestProbeHostTimeout(t *testing.T) {
+	// the first probe times out (packets dropped), the second one serves
+	// the description.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	dd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Application-URL", "http://"+r.Host+"/apps/")
+		w.Write([]byte(`<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:dial-multiscreen-org:device:dial:1</deviceType>
+    <friendlyName>Fake TV</friendlyName>
+    <UDN>uuid:fake-tv</UDN>
+  </device>
+</root>`))
+	}))
+	defer dd.Close()
+
+	var probes []ScanProbe
+	for _, srv := range []*httptest.Server{slow, dd} {
+		_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+		n, _ := strconv.Atoi(port)
+		probes = append(probes, ScanProbe{Port: n, Path: "/dd.xml"})
+	}
+	hc, err := newHTTPClient("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hc.Timeout = 200 * time.Millisecond
+	dev := probeHost(context.Background(), hc, net.ParseIP("127.0.0.1"), probes)
+	if dev == nil {
+		t.Fatalf("probeHost: no device found")
+	}
+	if dev.FriendlyName != "Fake TV" {
+		t.Fatalf("FriendlyName: want %q got %q", "Fake TV", dev.FriendlyName)
+	}
+}
@@ -2,34 +2,116 @@
 
 package dial
 
-import "net"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
 
-// wakeOnLan sends a magic packet to wake-on-lan a computer on the network, see
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+)
+
+const (
+	wolBurst         = 3
+	wolBurstInterval = 100 * time.Millisecond
+)
+
+var (
+	wolPorts = []int{9, 7} // discard and echo.
+
+	errBadSecureOn = errors.New("invalid SecureOn password")
+)
+
+// WakeOptions tunes the magic packets sent by TryWakeup(). The zero value uses
+// the defaults.
+type WakeOptions struct {
+	Password      string        // SecureOn password: 6 bytes (formatted as a MAC address) or 4 bytes (as an IPv4 address).
+	Ports         []int         // UDP destination ports (default 9 and 7).
+	Burst         int           // magic packets sent to each address and port per attempt (default 3).
+	BurstInterval time.Duration // interval between packets of a burst (default 100ms).
+	Addrs         []net.IP      // destination addresses (default the broadcast addresses, see TryWakeupWithOptions()).
+}
+
+// withDefaults returns a copy of opts with the unset values replaced by the
+// defaults.
+func (opts WakeOptions) withDefaults() WakeOptions {
+	if len(opts.Ports) == 0 {
+		opts.Ports = wolPorts
+	}
+	if opts.Burst < 1 {
+		opts.Burst = wolBurst
+	}
+	if opts.BurstInterval <= 0 {
+		opts.BurstInterval = wolBurstInterval
+	}
+	return opts
+}
+
+// wakeOnLan sends magic packets to wake-on-lan a computer on the network, see
 // https://en.wikipedia.org/wiki/Wake-on-LAN
 // The magic packet is composed by 6 times 0xff followed by 16 times the MAC
-// address and it's sent using UDP.
-// baddr is UDP's destination address, should be a broadcast address, usually
-// "255.255.255.255:9" is a good choice (limited broadcast address and
-// discard port).
-func wakeOnLan(mac, laddr, baddr string) error {
+// address (plus the SecureOn password, if any) and it's sent using UDP.
+// A burst of opts.Burst packets is sent to each of baddrs (should be broadcast
+// ips, see broadcastAddrs()) and each of opts.Ports. It fails only if no packet
+// could be sent at all.
+func wakeOnLan(ctx context.Context, mac, laddr string, baddrs []net.IP, opts WakeOptions) error {
+	opts = opts.withDefaults()
 	addr, err := net.ParseMAC(mac)
 	if err != nil {
 		return err
 	}
-	var d net.Dialer
+	magic := makeMagicPacket(addr)
+	if opts.Password != "" {
+		password, err := parseSecureOn(opts.Password)
+		if err != nil {
+			return err
+		}
+		magic = append(magic, password...)
+	}
+
+	var ua *net.UDPAddr
 	if laddr != "" {
-		if d.LocalAddr, err = net.ResolveUDPAddr("udp", laddr); err != nil {
+		if ua, err = net.ResolveUDPAddr("udp4", laddr); err != nil {
 			return err
 		}
 	}
-	magic := makeMagicPacket(addr)
-	conn, err := d.Dial("udp", baddr)
+	conn, err := net.ListenUDP("udp4", ua)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	_, err = conn.Write(magic)
-	return err
+
+	var sent int
+	var lastErr error
+	for i := 0; i < opts.Burst; i++ {
+		if i > 0 {
+			t := time.NewTimer(opts.BurstInterval)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+		for _, baddr := range baddrs {
+			for _, port := range opts.Ports {
+				raddr := &net.UDPAddr{IP: baddr, Port: port}
+				if _, err := conn.WriteTo(magic, raddr); err != nil {
+					lastErr = err
+					continue
+				}
+				sent++
+			}
+		}
+	}
+	log.Printf("sent %d magic packets for %s to %v ports %v", sent, mac, baddrs, opts.Ports)
+	if sent == 0 {
+		return lastErr
+	}
+	return nil
 }
 
 func makeMagicPacket(addr net.HardwareAddr) []byte {
@@ -42,3 +124,80 @@ func makeMagicPacket(addr net.HardwareAddr) []byte {
 	}
 	return magic
 }
+
+// parseSecureOn parses a SecureOn password, either 6 bytes formatted as a MAC
+// address or 4 bytes formatted as an IPv4 address.
+func parseSecureOn(password string) ([]byte, error) {
+	if hw, err := net.ParseMAC(password); err == nil && len(hw) == 6 {
+		return hw, nil
+	}
+	if ip := net.ParseIP(password).To4(); ip != nil && !strings.Contains(password, ":") {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("%q: %w", password, errBadSecureOn)
+}
+
+// broadcastAddrs returns the limited broadcast address plus the
+// subnet-directed broadcast address of each IPv4 network of the interface
+// which has laddr's ip, or of every up and broadcast capable interface if
+// laddr is empty. Subnet-directed broadcasts are needed when the limited one
+// isn't forwarded (e.g. by some access points).
+func broadcastAddrs(laddr string) []net.IP {
+	baddrs := []net.IP{net.IPv4bcast}
+	var ifaces []net.Interface
+	if laddr != "" {
+		host, _, err := net.SplitHostPort(laddr)
+		if err != nil {
+			host = laddr
+		}
+		ifi, err := ssdp.InterfaceByIP(net.ParseIP(host))
+		if err != nil {
+			log.Printf("broadcastAddrs: %s", err)
+			return baddrs
+		}
+		ifaces = append(ifaces, *ifi)
+	} else {
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			log.Printf("broadcastAddrs: %s", err)
+			return baddrs
+		}
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if a, ok := addr.(*net.IPNet); ok {
+				if bcast := directedBroadcast(a); bcast != nil {
+					baddrs = append(baddrs, bcast)
+				}
+			}
+		}
+	}
+	return baddrs
+}
+
+// directedBroadcast returns the subnet-directed broadcast address of the IPv4
+// network n, or nil if n is not IPv4 or doesn't have one (/31 and /32).
+func directedBroadcast(n *net.IPNet) net.IP {
+	ip, mask := n.IP.To4(), n.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:] // IPv4 mask in 16-byte form.
+	}
+	if ip == nil || len(mask) != net.IPv4len {
+		return nil
+	}
+	if ones, _ := mask.Size(); ones > 30 {
+		return nil
+	}
+	bcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast
+}
@@ -3,19 +3,117 @@
 package dial
 
 import (
+	"bytes"
+	"context"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestWakeOnLan(t *testing.T) {
-	mac := "" // put your target MAC here
-	baddr := "255.255.255.255:9"
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
 
-	if mac == "" {
-		t.SkipNow()
+	tests := []struct {
+		mac      string
+		password string
+		mustErr  bool
+		size     int
+	}{
+		{"10:dd:b1:c9:00:e4", "", false, 102},
+		{"10:dd:b1:c9:00:e4", "01:02:03:04:05:06", false, 108},
+		{"10:dd:b1:c9:00:e4", "192.168.1.1", false, 106},
+		{"10:dd:b1:c9:00:e4", "foo", true, 0},
+		{"foo", "", true, 0},
 	}
-	if err := wakeOnLan(mac, "", baddr); err != nil {
-		t.Fatalf("unexpected error: %s", err)
+
+	for i, test := range tests {
+		opts := WakeOptions{Password: test.password, Ports: []int{port}, Burst: 2, BurstInterval: time.Millisecond}
+		err := wakeOnLan(context.Background(), test.mac, "", []net.IP{net.IPv4(127, 0, 0, 1)}, opts)
+		if err == nil {
+			if test.mustErr {
+				t.Fatalf("tests[%d]: was expecting error but got nil", i)
+			}
+		} else {
+			if !test.mustErr {
+				t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		buf := make([]byte, 256)
+		for j := 0; j < opts.Burst; j++ {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("tests[%d]: packet %d: unexpected error: %s", i, j, err)
+			}
+			if n != test.size {
+				t.Fatalf("tests[%d]: packet %d: want %d bytes got %d", i, j, test.size, n)
+			}
+		}
+	}
+}
+
+func TestParseSecureOn(t *testing.T) {
+	tests := []struct {
+		password string
+		mustErr  bool
+		want     []byte
+	}{
+		{"01:02:03:04:05:06", false, []byte{1, 2, 3, 4, 5, 6}},
+		{"01-02-03-04-05-0a", false, []byte{1, 2, 3, 4, 5, 10}},
+		{"10.0.0.255", false, []byte{10, 0, 0, 255}},
+		{"::ffff:10.0.0.1", true, nil},
+		{"01:02:03:04:05:06:07:08", true, nil},
+		{"secret", true, nil},
+		{"", true, nil},
+	}
+
+	for i, test := range tests {
+		got, err := parseSecureOn(test.password)
+		if err == nil {
+			if test.mustErr {
+				t.Fatalf("tests[%d]: was expecting error but got nil", i)
+			}
+		} else {
+			if !test.mustErr {
+				t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Fatalf("tests[%d]: want %v got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestDirectedBroadcast(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"192.168.1.35/24", "192.168.1.255"},
+		{"10.1.2.3/8", "10.255.255.255"},
+		{"172.16.5.4/20", "172.16.15.255"},
+		{"192.168.1.1/31", ""},
+		{"192.168.1.1/32", ""},
+		{"fd00::1/64", ""},
+	}
+
+	for i, test := range tests {
+		ip, n, err := net.ParseCIDR(test.cidr)
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		n.IP = ip
+		got := directedBroadcast(n)
+		if (got == nil && test.want != "") || (got != nil && got.String() != test.want) {
+			t.Fatalf("tests[%d]: %s: want %q got %v", i, test.cidr, test.want, got)
+		}
 	}
 }
 
@@ -0,0 +1,126 @@
+// See license file for copyright and license details.
+
+package ssdp_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+	"github.com/MarcoLucidi01/ytcast/ssdp/ssdptest"
+)
+
+func TestAdvertise(t *testing.T) {
+	ip := ssdptest.MulticastIPOrSkip(t)
+	laddr := net.JoinHostPort(ip, "0")
+	ads := []*ssdp.Advertisement{
+		{SearchTarget: ssdp.RootDevice, UniqueServiceName: "uuid:ssdptest::upnp:rootdevice", Location: "http://" + ip + ":1234/dd.xml"},
+		{SearchTarget: ssdp.Dial, UniqueServiceName: "uuid:ssdptest::" + ssdp.Dial, Location: "http://" + ip + ":1234/dd.xml", Headers: http.Header{"WAKEUP": {"MAC=02:00:00:00:00:01;Timeout=10"}}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifyCh, err := ssdp.Listen(ctx, laddr)
+	if err != nil {
+		t.Skipf("Listen: %s", err)
+	}
+	advCtx, stopAdv := context.WithCancel(ctx)
+	advErr := make(chan error, 1)
+	go func() { advErr <- ssdp.Advertise(advCtx, laddr, ads) }()
+
+	tests := []struct {
+		targets []string
+		want    map[string]string // USN -> WAKEUP header.
+	}{
+		{[]string{ssdp.Dial, ssdp.RokuECP}, map[string]string{ads[1].UniqueServiceName: "MAC=02:00:00:00:00:01;Timeout=10"}},
+		{[]string{ssdp.All}, map[string]string{ads[0].UniqueServiceName: "", ads[1].UniqueServiceName: "MAC=02:00:00:00:00:01;Timeout=10"}},
+	}
+
+	for i, test := range tests {
+		ch, err := ssdp.Search(ctx, laddr, test.targets, 0, ssdp.SearchOptions{Mx: 1, Rounds: 2})
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		got := make(map[string]string)
+		for service := range ch {
+			if service.Location == ads[0].Location {
+				got[service.UniqueServiceName] = service.Headers.Get("WAKEUP")
+			}
+		}
+		if len(got) != len(test.want) {
+			t.Fatalf("tests[%d]: want %d services got %d", i, len(test.want), len(got))
+		}
+		for usn, wakeup := range test.want {
+			if v, ok := got[usn]; !ok || v != wakeup {
+				t.Fatalf("tests[%d]: %s: want WAKEUP %q got %q (found %t)", i, usn, wakeup, v, ok)
+			}
+		}
+	}
+
+	stopAdv()
+	if err := <-advErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Advertise: want %s got %v", context.Canceled, err)
+	}
+	// ssdp:alive at start and ssdp:byebye at the end for each ad.
+	counts := make(map[string]int)
+	timeout := time.After(time.Second)
+	for counts[ssdp.NotifyByebye] < len(ads) {
+		select {
+		case n := <-notifyCh:
+			if n.Location == ads[0].Location || n.Type == ssdp.NotifyByebye {
+				counts[n.Type]++
+			}
+		case <-timeout:
+			t.Fatalf("NOTIFY: want %d ssdp:byebye got %d", len(ads), counts[ssdp.NotifyByebye])
+		}
+	}
+	if counts[ssdp.NotifyAlive] != len(ads) {
+		t.Fatalf("NOTIFY: want %d ssdp:alive got %d", len(ads), counts[ssdp.NotifyAlive])
+	}
+}
+
+func TestServe(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ads := []*ssdp.Advertisement{
+		{SearchTarget: ssdp.Dial, UniqueServiceName: "uuid:ssdptest::" + ssdp.Dial, Location: "http://127.0.0.1:1234/dd.xml"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvErr := make(chan error, 1)
+	go func() { srvErr <- ssdp.Serve(ctx, conn, ads) }()
+
+	tests := []struct {
+		targets []string
+		found   bool
+	}{
+		{[]string{ssdp.Dial}, true},
+		{[]string{ssdp.All}, true},
+		{[]string{ssdp.RokuECP}, false},
+	}
+
+	for i, test := range tests {
+		ch, err := ssdp.SearchUnicast(ctx, "127.0.0.1:0", []string{conn.LocalAddr().String()}, test.targets, 0, ssdp.SearchOptions{Mx: 1, Rounds: 1})
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		found := false
+		for service := range ch {
+			found = found || service.UniqueServiceName == ads[0].UniqueServiceName
+		}
+		if found != test.found {
+			t.Fatalf("tests[%d]: found: want %t got %t", i, test.found, found)
+		}
+	}
+
+	cancel()
+	if err := <-srvErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Serve: want %s got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,323 @@
+// See license file for copyright and license details.
+
+// This file implements NOTIFY messages: listening for services announcing
+// themselves on the network and advertising local services (which includes
+// answering M-SEARCH requests).
+
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxAge = 30 * time.Minute
+	minMaxAge     = 2 * time.Second
+)
+
+var errBadAdvertisement = errors.New("advertisement must have SearchTarget, UniqueServiceName and Location")
+
+// Listen joins the SSDP multicast group and listens for NOTIFY messages until
+// ctx is done. If localAddr is not empty, the group is joined on the interface
+// which has localAddr's ip.
+func Listen(ctx context.Context, localAddr string) (chan *Notify, error) {
+	maddr, err := net.ResolveUDPAddr("udp4", MulticastAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ifi *net.Interface
+	if localAddr != "" {
+		laddr, err := net.ResolveUDPAddr("udp4", localAddr)
+		if err != nil {
+			return nil, err
+		}
+		if ifi, err = InterfaceByIP(laddr.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", ifi, maddr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("listening for NOTIFY udp %s", MulticastAddr)
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) // unblocks ReadFrom below.
+	ch := make(chan *Notify)
+	go func() {
+		defer stop()
+		defer close(ch)
+
+		buf := make([]byte, maxMsgSize)
+		for {
+			n, raddr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println(err)
+				}
+				return
+			}
+			notify, err := ParseNotify(buf[:n])
+			if err != nil {
+				// M-SEARCH requests from other clients end up here
+				// too, no need to log them.
+				if !errors.Is(err, errNotNotify) {
+					log.Printf("ParseNotify udp %s: %s", raddr, err)
+				}
+				continue
+			}
+			select {
+			case ch <- notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Advertisement is a local service advertised on the network by Advertise().
+type Advertisement struct {
+	SearchTarget      string        // ST header (NT in NOTIFY messages), e.g. RootDevice or Dial.
+	UniqueServiceName string        // USN header.
+	Location          string        // LOCATION header: URL to the UPnP description of the root device.
+	MaxAge            time.Duration // CACHE-CONTROL max-age (default 30 minutes).
+	Headers           http.Header   // additional headers (e.g. SERVER, BOOTID.UPNP.ORG or WAKEUP).
+}
+
+// Advertise advertises ads on the network until ctx is done: it announces them
+// with ssdp:alive NOTIFY messages (repeated before MaxAge expires), answers
+// M-SEARCH requests matching their SearchTarget (or ssdp:all) and finally says
+// ssdp:byebye. If localAddr is not empty, the SSDP multicast group is joined on
+// the interface which has localAddr's ip and messages are sent from it.
+// It returns ctx.Err() when ctx is done, or the first network error.
+func Advertise(ctx context.Context, localAddr string, ads []*Advertisement) error {
+	for _, ad := range ads {
+		if ad.SearchTarget == "" || ad.UniqueServiceName == "" || ad.Location == "" {
+			return errBadAdvertisement
+		}
+	}
+
+	maddr, err := net.ResolveUDPAddr("udp4", MulticastAddr)
+	if err != nil {
+		return err
+	}
+	var ifi *net.Interface
+	var laddr *net.UDPAddr
+	if localAddr != "" {
+		if laddr, err = net.ResolveUDPAddr("udp4", localAddr); err != nil {
+			return err
+		}
+		if ifi, err = InterfaceByIP(laddr.IP); err != nil {
+			return err
+		}
+		laddr.Port = 0
+	}
+
+	recvConn, err := net.ListenMulticastUDP("udp4", ifi, maddr)
+	if err != nil {
+		return err
+	}
+	defer recvConn.Close()
+	// ListenMulticastUDP disables multicast loopback, messages are sent
+	// from another socket so that local clients receive them too.
+	sendConn, err := net.ListenUDP("udp4", laddr)
+	if err != nil {
+		return err
+	}
+	defer sendConn.Close()
+	if err := setMulticastOpts(sendConn, false, mSearchTTL, true); err != nil {
+		log.Printf("setMulticastOpts: %s", err) // not fatal, system defaults are used.
+	}
+
+	a := &advertiser{ads: ads, conn: sendConn, maddr: maddr}
+	defer a.wg.Wait() // pending M-SEARCH responses, runs before closing sendConn.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops reannounce() on network errors, runs before a.wg.Wait().
+	log.Printf("advertising %d services udp %s via %s", len(ads), MulticastAddr, sendConn.LocalAddr())
+	a.notify(NotifyAlive)
+	a.wg.Add(1)
+	go a.reannounce(ctx)
+	err = a.serve(ctx, recvConn)
+	a.notify(NotifyByebye)
+	return err
+}
+
+// Serve answers M-SEARCH requests matching ads received on conn until ctx is
+// done, like Advertise() but without joining the SSDP multicast group and
+// without NOTIFY messages, e.g. to answer unicast M-SEARCH requests on a port
+// other than 1900. Responses are sent from conn, which is closed when ctx is
+// done. It returns ctx.Err() when ctx is done, or the first network error.
+func Serve(ctx context.Context, conn net.PacketConn, ads []*Advertisement) error {
+	for _, ad := range ads {
+		if ad.SearchTarget == "" || ad.UniqueServiceName == "" || ad.Location == "" {
+			return errBadAdvertisement
+		}
+	}
+	defer conn.Close()
+	a := &advertiser{ads: ads, conn: conn}
+	defer a.wg.Wait() // pending M-SEARCH responses, runs before closing conn.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops pending M-SEARCH responses on network errors.
+	log.Printf("answering M-SEARCH for %d services udp %s", len(ads), conn.LocalAddr())
+	return a.serve(ctx, conn)
+}
+
+// advertiser sends NOTIFY messages and M-SEARCH responses for Advertise() and
+// Serve().
+type advertiser struct {
+	ads   []*Advertisement
+	conn  net.PacketConn
+	maddr *net.UDPAddr // nil if NOTIFY messages are not sent.
+	wg    sync.WaitGroup
+}
+
+// serve answers the M-SEARCH requests received on conn until ctx is done (conn
+// is closed then) or a network error occurs.
+func (a *advertiser) serve(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) // unblocks ReadFrom below.
+	defer stop()
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, raddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		target, mx, err := parseMSearch(buf[:n])
+		if err != nil {
+			continue // NOTIFY messages from other services end up here too.
+		}
+		for _, ad := range a.ads {
+			if target == All || target == ad.SearchTarget {
+				a.wg.Add(1)
+				go a.respond(ctx, ad, raddr, mx)
+			}
+		}
+	}
+}
+
+// notify multicasts a NOTIFY message of type nts for each advertisement.
+func (a *advertiser) notify(nts string) {
+	for _, ad := range a.ads {
+		msg := bytes.NewBufferString("NOTIFY * HTTP/1.1\r\n")
+		fmt.Fprintf(msg, "HOST: %s\r\n", MulticastAddr)
+		if nts != NotifyByebye {
+			fmt.Fprintf(msg, "CACHE-CONTROL: max-age=%d\r\n", int(maxAge(ad).Seconds()))
+			fmt.Fprintf(msg, "LOCATION: %s\r\n", ad.Location)
+		}
+		fmt.Fprintf(msg, "NT: %s\r\n", ad.SearchTarget)
+		fmt.Fprintf(msg, "NTS: %s\r\n", nts)
+		fmt.Fprintf(msg, "USN: %s\r\n", ad.UniqueServiceName)
+		writeHeaders(msg, ad.Headers)
+		msg.WriteString("\r\n")
+		log.Printf("NOTIFY udp %s NT %q NTS %s", a.maddr, ad.SearchTarget, nts)
+		if _, err := a.conn.WriteTo(msg.Bytes(), a.maddr); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// reannounce repeats ssdp:alive NOTIFY messages at half the shortest MaxAge
+// until ctx is done.
+func (a *advertiser) reannounce(ctx context.Context) {
+	defer a.wg.Done()
+	interval := defaultMaxAge
+	for _, ad := range a.ads {
+		interval = min(interval, maxAge(ad))
+	}
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			a.notify(NotifyAlive)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// respond sends the M-SEARCH response for ad to raddr after a random delay
+// of at most mx seconds, as required by the protocol to avoid bursts.
+func (a *advertiser) respond(ctx context.Context, ad *Advertisement, raddr net.Addr, mx int) {
+	defer a.wg.Done()
+	if mx > 0 {
+		t := time.NewTimer(time.Duration(rand.Int64N(int64(time.Duration(mx) * time.Second))))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+	resp := bytes.NewBufferString("HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(resp, "CACHE-CONTROL: max-age=%d\r\n", int(maxAge(ad).Seconds()))
+	resp.WriteString("EXT:\r\n")
+	fmt.Fprintf(resp, "LOCATION: %s\r\n", ad.Location)
+	fmt.Fprintf(resp, "ST: %s\r\n", ad.SearchTarget)
+	fmt.Fprintf(resp, "USN: %s\r\n", ad.UniqueServiceName)
+	writeHeaders(resp, ad.Headers)
+	resp.WriteString("\r\n")
+	log.Printf("M-SEARCH response udp %s ST %q", raddr, ad.SearchTarget)
+	if _, err := a.conn.WriteTo(resp.Bytes(), raddr); err != nil {
+		log.Println(err)
+	}
+}
+
+func maxAge(ad *Advertisement) time.Duration {
+	if ad.MaxAge <= 0 {
+		return defaultMaxAge
+	}
+	return max(ad.MaxAge, minMaxAge)
+}
+
+// writeHeaders writes h to buf sorted by key, keys are written as they are
+// (SSDP headers are case insensitive but some devices are picky).
+func writeHeaders(buf *bytes.Buffer, h http.Header) {
+	for _, k := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+}
+
+// parseMSearch parses an M-SEARCH request and returns its ST and MX (0 if
+// missing, as in unicast requests, capped to 5).
+func parseMSearch(data []byte) (string, int, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewBuffer(data)))
+	if err != nil {
+		return "", 0, err
+	}
+	defer req.Body.Close()
+
+	if req.Method != "M-SEARCH" || strings.Trim(req.Header.Get("MAN"), `" `) != mSearchMan {
+		return "", 0, fmt.Errorf("%s: %w", req.Method, errNotMSearch)
+	}
+	target := strings.TrimSpace(req.Header.Get("ST"))
+	if target == "" {
+		return "", 0, errNoST
+	}
+	mx, err := strconv.Atoi(strings.TrimSpace(req.Header.Get("MX")))
+	if err != nil || mx < 0 {
+		mx = 0
+	}
+	return target, min(mx, mSearchMaxMx), nil
+}
@@ -0,0 +1,85 @@
+// See license file for copyright and license details.
+
+package ssdp
+
+import (
+	"testing"
+)
+
+func TestParseMSearch(t *testing.T) {
+	tests := []struct {
+		req     []byte
+		mustErr bool
+		target  string
+		mx      int
+	}{
+		{
+			req: []byte("M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"ST: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"MX: 3\r\n" +
+				"\r\n"),
+			target: Dial,
+			mx:     3,
+		}, {
+			req: []byte("M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 192.168.1.1:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"ST: ssdp:all\r\n" +
+				"\r\n"),
+			target: All,
+			mx:     0, // unicast.
+		}, {
+			req: []byte("M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"ST: upnp:rootdevice\r\n" +
+				"MX: 120\r\n" +
+				"\r\n"),
+			target: RootDevice,
+			mx:     5,
+		}, {
+			req: []byte("M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"ST: upnp:rootdevice\r\n" +
+				"MX: 3\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			req: []byte("M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"MX: 3\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			req: []byte("NOTIFY * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"NT: upnp:rootdevice\r\n" +
+				"NTS: ssdp:alive\r\n" +
+				"\r\n"),
+			mustErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		target, mx, err := parseMSearch(test.req)
+		if err == nil {
+			if test.mustErr {
+				t.Fatalf("tests[%d]: was expecting error but got nil", i)
+			}
+		} else {
+			if !test.mustErr {
+				t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if target != test.target {
+			t.Fatalf("tests[%d]: target: want %q got %q", i, test.target, target)
+		}
+		if mx != test.mx {
+			t.Fatalf("tests[%d]: mx: want %d got %d", i, test.mx, mx)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// See license file for copyright and license details.
+
+// This file implements M-SEARCH requests, both multicast and unicast, sent in
+// several rounds since UDP packets easily get lost.
+
+package ssdp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mSearchMan       = "ssdp:discover"
+	mSearchMx        = 3
+	mSearchMaxMx     = 5
+	mSearchRounds    = 3
+	mSearchMaxJitter = 100 * time.Millisecond
+	mSearchTTL       = 2 // as recommended by UPnP Device Architecture 1.1.
+)
+
+// SearchOptions tunes the M-SEARCH requests. The zero value uses the defaults.
+type SearchOptions struct {
+	Mx         int  // MX header value in seconds (1-5, default 3): the maximum time devices wait before responding.
+	Rounds     int  // number of times the request is sent within the timeout (default 3), since UDP packets get lost.
+	TTL        int  // multicast TTL (hop limit for IPv6) of the requests (default 2).
+	NoLoopback bool // don't loop multicast requests back to the local host.
+}
+
+// withDefaults returns a copy of opts with the unset (or invalid) values
+// replaced by the defaults.
+func (opts SearchOptions) withDefaults() SearchOptions {
+	if opts.Mx < 1 || opts.Mx > mSearchMaxMx {
+		opts.Mx = mSearchMx
+	}
+	if opts.Rounds < 1 {
+		opts.Rounds = mSearchRounds
+	}
+	if opts.TTL < 1 {
+		opts.TTL = mSearchTTL
+	}
+	return opts
+}
+
+// MinTimeout returns the minimum search timeout with opts, enough to receive
+// responses to the last request.
+func (opts SearchOptions) MinTimeout() time.Duration {
+	return time.Duration(opts.withDefaults().Mx)*time.Second + 1*time.Second
+}
+
+// Search searches for network services matching any of targets (e.g. All,
+// RootDevice or Dial) sending multicast M-SEARCH requests. If localAddr is an
+// IPv6 address, the requests are sent to both the link-local and site-local
+// IPv6 SSDP multicast groups, otherwise to the IPv4 one. Each service is
+// received once (per search target). The search stops after timeout or when
+// ctx is done.
+func Search(ctx context.Context, localAddr string, targets []string, timeout time.Duration, opts SearchOptions) (chan *Service, error) {
+	opts = opts.withDefaults()
+	timeout = clamp(timeout, opts.MinTimeout(), MaxTimeout)
+
+	network := "udp4"
+	groups := []string{MulticastAddr}
+	if IsIPv6Addr(localAddr) {
+		network = "udp6"
+		groups = []string{MulticastAddr6LinkLocal, MulticastAddr6SiteLocal}
+	}
+
+	var laddr *net.UDPAddr
+	var err error
+	if localAddr != "" {
+		if laddr, err = net.ResolveUDPAddr(network, localAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	var maddrs []*net.UDPAddr
+	for _, group := range groups {
+		maddr, err := net.ResolveUDPAddr(network, group)
+		if err != nil {
+			return nil, err
+		}
+		if maddr.IP.IsLinkLocalMulticast() {
+			// link-local multicast needs the zone (interface) to
+			// send the request through.
+			if maddr.Zone, err = zoneOf(laddr); err != nil {
+				return nil, err
+			}
+		}
+		maddrs = append(maddrs, maddr)
+	}
+
+	s := &search{targets: targets, timeout: timeout, opts: opts, raddrs: maddrs, hosts: groups, multicast: true}
+	return s.start(ctx, network, laddr)
+}
+
+// SearchUnicast is like Search(), but sends unicast M-SEARCH requests to each
+// of hosts (host or host:port, the port defaults to 1900), for example when
+// the devices are not reachable with multicast (routed networks).
+func SearchUnicast(ctx context.Context, localAddr string, hosts, targets []string, timeout time.Duration, opts SearchOptions) (chan *Service, error) {
+	opts = opts.withDefaults()
+	timeout = clamp(timeout, opts.MinTimeout(), MaxTimeout)
+
+	network := "udp"
+	var laddr *net.UDPAddr
+	var err error
+	if localAddr != "" {
+		if laddr, err = net.ResolveUDPAddr(network, localAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	var raddrs []*net.UDPAddr
+	var hostHeaders []string
+	for _, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), Port)
+		}
+		raddr, err := net.ResolveUDPAddr(network, host)
+		if err != nil {
+			return nil, err
+		}
+		raddrs = append(raddrs, raddr)
+		hostHeaders = append(hostHeaders, host)
+	}
+
+	s := &search{targets: targets, timeout: timeout, opts: opts, raddrs: raddrs, hosts: hostHeaders}
+	return s.start(ctx, network, laddr)
+}
+
+// search is an M-SEARCH request for each of targets sent in opts.Rounds rounds
+// to each of raddrs (with the HOST header taken from hosts) from a single
+// socket. The rounds are spread (with some jitter) so that devices have at
+// least MX seconds to respond to the last one before timeout. Responses are
+// aggregated across rounds i.e. each service is reported once.
+type search struct {
+	targets   []string
+	timeout   time.Duration
+	opts      SearchOptions
+	raddrs    []*net.UDPAddr
+	hosts     []string
+	multicast bool // MX is only sent to multicast addresses, unicast requests must not include it.
+
+	conn  *net.UDPConn
+	mu    sync.Mutex
+	round int             // current round, starting from 1.
+	resps []int           // responses received in each round.
+	news  []int           // new services received in each round.
+	seen  map[string]bool // USN and ST of the services received so far.
+}
+
+// start sends the first round of requests and returns the channel where the
+// discovered services are sent, which is closed when the search is over.
+func (s *search) start(ctx context.Context, network string, laddr *net.UDPAddr) (chan *Service, error) {
+	var err error
+	s.conn, err = net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	if s.multicast {
+		if err := setMulticastOpts(s.conn, network == "udp6", s.opts.TTL, !s.opts.NoLoopback); err != nil {
+			log.Printf("setMulticastOpts: %s", err) // not fatal, system defaults are used.
+		}
+	}
+	if err := s.conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		s.conn.Close() // can't defer before goroutine.
+		return nil, err
+	}
+	s.resps = make([]int, s.opts.Rounds)
+	s.news = make([]int, s.opts.Rounds)
+	s.seen = make(map[string]bool)
+	if err := s.send(); err != nil {
+		s.conn.Close() // can't defer before goroutine.
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go s.retransmit(ctx, done)
+
+	stop := context.AfterFunc(ctx, func() { s.conn.Close() }) // unblocks ReadFrom below.
+	ch := make(chan *Service)
+	go func() {
+		defer stop()
+		defer s.conn.Close()
+		defer close(ch)
+		defer close(done)
+		defer s.logRound(true)
+
+		buf := make([]byte, maxMsgSize)
+		for {
+			n, raddr, err := s.conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+					log.Println(err)
+				}
+				return
+			}
+			service, err := ParseSearchResponse(buf[:n])
+			if err != nil {
+				log.Printf("ParseSearchResponse udp %s: %s", raddr, err)
+				continue
+			}
+			if !s.add(service) {
+				continue // already received in this or a previous round.
+			}
+			if ua, ok := raddr.(*net.UDPAddr); ok {
+				service.Zone = ua.Zone
+				service.Location = WithZone(service.Location, service.Zone)
+			}
+			log.Printf("discovered service %s ST %q", service.Location, service.SearchTarget)
+			select {
+			case ch <- service:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// send sends the current round of requests, one per raddr and target.
+func (s *search) send() error {
+	s.mu.Lock()
+	s.round++
+	round := s.round
+	s.mu.Unlock()
+	for i, raddr := range s.raddrs {
+		for _, target := range s.targets {
+			req := bytes.NewBufferString("M-SEARCH * HTTP/1.1\r\n")
+			fmt.Fprintf(req, "HOST: %s\r\n", s.hosts[i])
+			fmt.Fprintf(req, "MAN: %q\r\n", mSearchMan) // must be quoted
+			fmt.Fprintf(req, "ST: %s\r\n", target)
+			if s.multicast {
+				fmt.Fprintf(req, "MX: %d\r\n", s.opts.Mx)
+			}
+			req.WriteString("\r\n")
+			log.Printf("M-SEARCH udp %s ST %q round %d/%d timeout %s via %s", raddr, target, round, s.opts.Rounds, s.timeout, s.conn.LocalAddr())
+			if _, err := s.conn.WriteTo(req.Bytes(), raddr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retransmit sends the remaining rounds of requests until done is closed or
+// ctx is done.
+func (s *search) retransmit(ctx context.Context, done chan struct{}) {
+	// the last round must leave MX seconds to the devices to respond.
+	start := time.Now()
+	window := s.timeout - time.Duration(s.opts.Mx)*time.Second
+	interval := window / time.Duration(s.opts.Rounds)
+	for i := 1; i < s.opts.Rounds; i++ {
+		jitter := time.Duration(rand.Int64N(int64(mSearchMaxJitter)))
+		t := time.NewTimer(time.Until(start.Add(time.Duration(i)*interval + jitter)))
+		select {
+		case <-t.C:
+		case <-done:
+			t.Stop()
+			return
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		s.logRound(false)
+		if err := s.send(); err != nil {
+			select {
+			case <-done: // conn closed by the reader.
+			default:
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// add records a response to the current round and returns true if service is
+// new i.e. it hasn't been received in this or a previous round.
+func (s *search) add(service *Service) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resps[s.round-1]++
+	key := service.UniqueServiceName + " " + service.SearchTarget
+	if s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+	s.news[s.round-1]++
+	return true
+}
+
+// logRound logs a summary of the current round, plus the total if last.
+func (s *search) logRound(last bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log.Printf("M-SEARCH via %s round %d/%d: %d responses, %d new services", s.conn.LocalAddr(), s.round, s.opts.Rounds, s.resps[s.round-1], s.news[s.round-1])
+	if last {
+		log.Printf("M-SEARCH via %s: %d services in %d rounds", s.conn.LocalAddr(), len(s.seen), s.round)
+	}
+}
@@ -0,0 +1,24 @@
+// See license file for copyright and license details.
+
+package ssdp
+
+import (
+	"testing"
+)
+
+func TestSearchOptionsWithDefaults(t *testing.T) {
+	tests := []struct {
+		opts SearchOptions
+		want SearchOptions
+	}{
+		{SearchOptions{}, SearchOptions{Mx: 3, Rounds: 3, TTL: 2}},
+		{SearchOptions{Mx: 1, Rounds: 5, TTL: 4, NoLoopback: true}, SearchOptions{Mx: 1, Rounds: 5, TTL: 4, NoLoopback: true}},
+		{SearchOptions{Mx: 6, Rounds: -1, TTL: -1}, SearchOptions{Mx: 3, Rounds: 3, TTL: 2}},
+	}
+
+	for i, test := range tests {
+		if got := test.opts.withDefaults(); got != test.want {
+			t.Fatalf("tests[%d]: withDefaults(): want %+v got %+v", i, test.want, got)
+		}
+	}
+}
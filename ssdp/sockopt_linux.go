@@ -0,0 +1,36 @@
+// See license file for copyright and license details.
+
+package ssdp
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastOpts sets the TTL (hop limit for IPv6) and the loopback of the
+// multicast packets sent through conn.
+func setMulticastOpts(conn *net.UDPConn, ipv6 bool, ttl int, loopback bool) error {
+	level, ttlOpt, loopOpt := syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, syscall.IP_MULTICAST_LOOP
+	if ipv6 {
+		level, ttlOpt, loopOpt = syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, syscall.IPV6_MULTICAST_LOOP
+	}
+	loop := 0
+	if loopback {
+		loop = 1
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		if sockErr = syscall.SetsockoptInt(int(fd), level, ttlOpt, ttl); sockErr != nil {
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), level, loopOpt, loop)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,16 @@
+// See license file for copyright and license details.
+
+//go:build !linux
+
+package ssdp
+
+import (
+	"errors"
+	"net"
+)
+
+// setMulticastOpts is not supported on this platform, the system defaults are
+// used.
+func setMulticastOpts(conn *net.UDPConn, ipv6 bool, ttl int, loopback bool) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,252 @@
+// See license file for copyright and license details.
+
+// Package ssdp implements the SSDP (Simple Service Discovery Protocol) part of
+// UPnP: M-SEARCH requests (multicast and unicast) to search for services on
+// the network, NOTIFY messages to listen for services announcing themselves
+// and to advertise local services.
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MulticastAddr           = "239.255.255.250:1900" // IPv4 SSDP multicast group.
+	MulticastAddr6LinkLocal = "[FF02::C]:1900"       // IPv6 link-local SSDP multicast group.
+	MulticastAddr6SiteLocal = "[FF05::C]:1900"       // IPv6 site-local SSDP multicast group.
+	Port                    = "1900"
+
+	// well-known search targets.
+	All           = "ssdp:all"
+	RootDevice    = "upnp:rootdevice"
+	Dial          = "urn:dial-multiscreen-org:service:dial:1"
+	RokuECP       = "roku:ecp"
+	MediaRenderer = "urn:schemas-upnp-org:device:MediaRenderer:1"
+
+	// NOTIFY message types (NTS header).
+	NotifyAlive  = "ssdp:alive"  // a service joined the network (or it's still there).
+	NotifyUpdate = "ssdp:update" // a service changed its description.
+	NotifyByebye = "ssdp:byebye" // a service is leaving the network.
+
+	MinTimeout = time.Duration(mSearchMx)*time.Second + 1*time.Second // minimum search timeout with default SearchOptions.
+	MaxTimeout = 2 * time.Minute                                      // maximum search timeout.
+
+	maxMsgSize = 4096
+)
+
+var (
+	errBadHttpStatus = errors.New("bad HTTP response status")
+	errNoUSN         = errors.New("missing USN header")
+	errNoLocation    = errors.New("missing LOCATION header")
+	errNoST          = errors.New("missing ST header")
+	errNoNT          = errors.New("missing NT header")
+	errBadNTS        = errors.New("invalid NTS header")
+	errNotNotify     = errors.New("not a NOTIFY request")
+	errNotMSearch    = errors.New("not an M-SEARCH request")
+	errNoIface       = errors.New("no interface with address")
+)
+
+// Service is a network service discovered with an M-SEARCH request or
+// announced with a NOTIFY message.
+type Service struct {
+	UniqueServiceName string      // USN header: composite unique service identifier.
+	Location          string      // LOCATION header: URL to the UPnP description of the root device.
+	SearchTarget      string      // ST header (NT for NOTIFY messages): single URI, depends on the ST of the M-SEARCH request.
+	Headers           http.Header // all headers of the message (e.g. CACHE-CONTROL, SERVER, BOOTID.UPNP.ORG).
+	Zone              string      // IPv6 zone of the interface the message came from.
+}
+
+// Notify is a NOTIFY message multicasted by a network service to advertise its
+// presence (or its departure) on the network.
+type Notify struct {
+	*Service        // SearchTarget holds the NT header, Location is empty for ssdp:byebye.
+	Type     string // NTS header: ssdp:alive, ssdp:update or ssdp:byebye.
+}
+
+// ParseSearchResponse parses an M-SEARCH response.
+func ParseSearchResponse(data []byte) (*Service, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewBuffer(data)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %w", resp.Status, errBadHttpStatus)
+	}
+
+	service := &Service{Headers: resp.Header}
+
+	if service.UniqueServiceName = strings.TrimSpace(service.Headers.Get("USN")); service.UniqueServiceName == "" {
+		return nil, errNoUSN
+	}
+	if service.Location = strings.TrimSpace(service.Headers.Get("LOCATION")); service.Location == "" {
+		return nil, errNoLocation
+	}
+	if service.SearchTarget = strings.TrimSpace(service.Headers.Get("ST")); service.SearchTarget == "" {
+		return nil, errNoST
+	}
+	return service, nil
+}
+
+// ParseNotify parses a NOTIFY message.
+func ParseNotify(data []byte) (*Notify, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewBuffer(data)))
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+
+	if req.Method != "NOTIFY" {
+		return nil, fmt.Errorf("%s: %w", req.Method, errNotNotify)
+	}
+
+	notify := &Notify{Service: &Service{Headers: req.Header}}
+
+	switch notify.Type = strings.TrimSpace(notify.Headers.Get("NTS")); notify.Type {
+	case NotifyAlive, NotifyUpdate, NotifyByebye:
+	default:
+		return nil, fmt.Errorf("%q: %w", notify.Type, errBadNTS)
+	}
+	if notify.UniqueServiceName = strings.TrimSpace(notify.Headers.Get("USN")); notify.UniqueServiceName == "" {
+		return nil, errNoUSN
+	}
+	if notify.SearchTarget = strings.TrimSpace(notify.Headers.Get("NT")); notify.SearchTarget == "" {
+		return nil, errNoNT
+	}
+	// ssdp:byebye doesn't carry a LOCATION header.
+	notify.Location = strings.TrimSpace(notify.Headers.Get("LOCATION"))
+	if notify.Location == "" && notify.Type != NotifyByebye {
+		return nil, errNoLocation
+	}
+	return notify, nil
+}
+
+// MulticastAddrs returns local addresses (ip:0) of each up and multicast
+// capable network interface: the first IPv4 address and the first IPv6
+// link-local address (with zone), if any.
+func MulticastAddrs() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var laddrs []string
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			log.Printf("%s: Addrs: %s", ifi.Name, err)
+			continue
+		}
+		var v4, v6 string
+		for _, addr := range addrs {
+			a, ok := addr.(*net.IPNet)
+			switch {
+			case !ok:
+			case a.IP.To4() != nil && v4 == "":
+				v4 = net.JoinHostPort(a.IP.String(), "0")
+			case a.IP.To4() == nil && a.IP.IsLinkLocalUnicast() && v6 == "":
+				v6 = net.JoinHostPort(a.IP.String()+"%"+ifi.Name, "0")
+			}
+		}
+		for _, laddr := range []string{v4, v6} {
+			if laddr != "" {
+				laddrs = append(laddrs, laddr)
+			}
+		}
+	}
+	return laddrs, nil
+}
+
+// IsIPv6Addr reports whether addr is an IPv6 host:port address.
+func IsIPv6Addr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if i := strings.IndexByte(host, '%'); i > -1 {
+		host = host[:i] // strip zone.
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// WithZone adds zone to rawurl's host if it's an IPv6 link-local address
+// without one, because such addresses can't be dialed without a zone.
+func WithZone(rawurl, zone string) string {
+	if zone == "" {
+		return rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	ip := net.ParseIP(u.Hostname()) // nil if it already has a zone.
+	if ip == nil || ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+		return rawurl
+	}
+	host := ip.String() + "%" + zone
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else {
+		u.Host = "[" + host + "]"
+	}
+	return u.String()
+}
+
+// InterfaceByIP returns the network interface which has ip.
+func InterfaceByIP(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if a, ok := addr.(*net.IPNet); ok && a.IP.Equal(ip) {
+				return &ifi, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w %s", errNoIface, ip)
+}
+
+// zoneOf returns the zone of laddr, i.e. the name of the interface laddr
+// belongs to.
+func zoneOf(laddr *net.UDPAddr) (string, error) {
+	if laddr == nil {
+		return "", fmt.Errorf("%w: missing local address", errNoIface)
+	}
+	if laddr.Zone != "" {
+		return laddr.Zone, nil
+	}
+	ifi, err := InterfaceByIP(laddr.IP)
+	if err != nil {
+		return "", err
+	}
+	return ifi.Name, nil
+}
+
+func clamp(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
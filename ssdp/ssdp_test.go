@@ -0,0 +1,227 @@
+// See license file for copyright and license details.
+
+package ssdp
+
+import (
+	"testing"
+)
+
+func TestParseSearchResponse(t *testing.T) {
+	tests := []struct {
+		resp    []byte
+		mustErr bool
+		service *Service
+	}{
+		{
+			resp: []byte("HTTP/1.1 200 OK\r\n" +
+				"LOCATION: http://192.168.1.1:52235/dd.xml\r\n" +
+				"CACHE-CONTROL: max-age=1800\r\n" +
+				"EXT:\r\n" +
+				"BOOTID.UPNP.ORG: 1\r\n" +
+				"SERVER: OS/version UPnP/1.1 product/version\r\n" +
+				"USN: uuid-foo-bar-baz\r\n" +
+				"ST: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"WAKEUP: MAC=10:dd:b1:c9:00:e4;Timeout=10\r\n" +
+				"\r\n"),
+			mustErr: false,
+			service: &Service{
+				UniqueServiceName: "uuid-foo-bar-baz",
+				Location:          "http://192.168.1.1:52235/dd.xml",
+				SearchTarget:      Dial,
+			},
+		}, {
+			resp: []byte("HTTP/1.1 200 OK\r\n" +
+				"FOO\r\n" +
+				"BAR\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			resp:    []byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"),
+			mustErr: true,
+		}, {
+
+			resp: []byte("HTTP/1.1 200 OK\r\n" +
+				"LOCATION: http://192.168.1.1:52235/dd.xml\r\n" +
+				"ST: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+
+			resp: []byte("HTTP/1.1 200 OK\r\n" +
+				"USN: device UUID\r\n" +
+				"ST: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			resp: []byte("HTTP/1.1 200 OK\r\n" +
+				"LOCATION: http://192.168.1.1:52235/dd.xml\r\n" +
+				"USN: device UUID\r\n" +
+				"\r\n"),
+			mustErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		service, err := ParseSearchResponse(test.resp)
+		if err == nil {
+			if test.mustErr {
+				t.Fatalf("tests[%d]: was expecting error but got nil", i)
+			}
+		} else {
+			if !test.mustErr {
+				t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if test.service.UniqueServiceName != service.UniqueServiceName {
+			t.Fatalf("tests[%d]: service.UniqueServiceName: want %q got %q", i, test.service.UniqueServiceName, service.UniqueServiceName)
+		}
+		if test.service.Location != service.Location {
+			t.Fatalf("tests[%d]: service.Location: want %q got %q", i, test.service.Location, service.Location)
+		}
+		if test.service.SearchTarget != service.SearchTarget {
+			t.Fatalf("tests[%d]: service.SearchTarget: want %q got %q", i, test.service.SearchTarget, service.SearchTarget)
+		}
+	}
+}
+
+func TestParseNotify(t *testing.T) {
+	tests := []struct {
+		req     []byte
+		mustErr bool
+		notify  *Notify
+	}{
+		{
+			req: []byte("NOTIFY * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"CACHE-CONTROL: max-age=1800\r\n" +
+				"LOCATION: http://192.168.1.1:52235/dd.xml\r\n" +
+				"NT: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"NTS: ssdp:alive\r\n" +
+				"SERVER: OS/version UPnP/1.1 product/version\r\n" +
+				"USN: uuid-foo-bar-baz\r\n" +
+				"\r\n"),
+			mustErr: false,
+			notify: &Notify{
+				Service: &Service{
+					UniqueServiceName: "uuid-foo-bar-baz",
+					Location:          "http://192.168.1.1:52235/dd.xml",
+					SearchTarget:      Dial,
+				},
+				Type: NotifyAlive,
+			},
+		}, {
+			req: []byte("NOTIFY * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"NT: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"NTS: ssdp:byebye\r\n" +
+				"USN: uuid-foo-bar-baz\r\n" +
+				"\r\n"),
+			mustErr: false,
+			notify: &Notify{
+				Service: &Service{
+					UniqueServiceName: "uuid-foo-bar-baz",
+					SearchTarget:      Dial,
+				},
+				Type: NotifyByebye,
+			},
+		}, {
+			req: []byte("M-SEARCH * HTTP/1.1\r\n" +
+				"HOST: 239.255.255.250:1900\r\n" +
+				"MAN: \"ssdp:discover\"\r\n" +
+				"ST: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"MX: 3\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			req: []byte("NOTIFY * HTTP/1.1\r\n" +
+				"NT: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"NTS: ssdp:alive\r\n" +
+				"USN: uuid-foo-bar-baz\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			req: []byte("NOTIFY * HTTP/1.1\r\n" +
+				"LOCATION: http://192.168.1.1:52235/dd.xml\r\n" +
+				"NT: urn:dial-multiscreen-org:service:dial:1\r\n" +
+				"NTS: ssdp:foo\r\n" +
+				"USN: uuid-foo-bar-baz\r\n" +
+				"\r\n"),
+			mustErr: true,
+		}, {
+			req: []byte("NOTIFY * HTTP/1.1\r\n" +
+				"LOCATION: http://192.168.1.1:52235/dd.xml\r\n" +
+				"NTS: ssdp:alive\r\n" +
+				"USN: uuid-foo-bar-baz\r\n" +
+				"\r\n"),
+			mustErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		notify, err := ParseNotify(test.req)
+		if err == nil {
+			if test.mustErr {
+				t.Fatalf("tests[%d]: was expecting error but got nil", i)
+			}
+		} else {
+			if !test.mustErr {
+				t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if test.notify.UniqueServiceName != notify.UniqueServiceName {
+			t.Fatalf("tests[%d]: notify.UniqueServiceName: want %q got %q", i, test.notify.UniqueServiceName, notify.UniqueServiceName)
+		}
+		if test.notify.Location != notify.Location {
+			t.Fatalf("tests[%d]: notify.Location: want %q got %q", i, test.notify.Location, notify.Location)
+		}
+		if test.notify.SearchTarget != notify.SearchTarget {
+			t.Fatalf("tests[%d]: notify.SearchTarget: want %q got %q", i, test.notify.SearchTarget, notify.SearchTarget)
+		}
+		if test.notify.Type != notify.Type {
+			t.Fatalf("tests[%d]: notify.Type: want %q got %q", i, test.notify.Type, notify.Type)
+		}
+	}
+}
+
+func TestWithZone(t *testing.T) {
+	tests := []struct {
+		rawurl string
+		zone   string
+		want   string
+	}{
+		{"http://192.168.1.1:52235/dd.xml", "eth0", "http://192.168.1.1:52235/dd.xml"},
+		{"http://[fe80::1]:52235/dd.xml", "", "http://[fe80::1]:52235/dd.xml"},
+		{"http://[fe80::1]:52235/dd.xml", "eth0", "http://[fe80::1%25eth0]:52235/dd.xml"},
+		{"http://[fe80::1]/apps", "wlan0", "http://[fe80::1%25wlan0]/apps"},
+		{"http://[fe80::1%25eth0]:52235/dd.xml", "wlan0", "http://[fe80::1%25eth0]:52235/dd.xml"},
+		{"http://[fd00::1]:52235/dd.xml", "eth0", "http://[fd00::1]:52235/dd.xml"},
+	}
+
+	for i, test := range tests {
+		if got := WithZone(test.rawurl, test.zone); got != test.want {
+			t.Fatalf("tests[%d]: WithZone(%q, %q): want %q got %q", i, test.rawurl, test.zone, test.want, got)
+		}
+	}
+}
+
+func TestIsIPv6Addr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"", false},
+		{"192.168.1.1:0", false},
+		{"localhost:0", false},
+		{"[fe80::1%eth0]:0", true},
+		{"[fd00::1]:1900", true},
+		{"fd00::1", false}, // not host:port.
+	}
+
+	for i, test := range tests {
+		if got := IsIPv6Addr(test.addr); got != test.want {
+			t.Fatalf("tests[%d]: IsIPv6Addr(%q): want %t got %t", i, test.addr, test.want, got)
+		}
+	}
+}
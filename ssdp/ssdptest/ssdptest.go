@@ -0,0 +1,28 @@
+// See license file for copyright and license details.
+
+// Package ssdptest provides utilities for tests which need SSDP multicast.
+package ssdptest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/MarcoLucidi01/ytcast/ssdp"
+)
+
+// MulticastIPOrSkip returns the IPv4 address of an up and multicast capable
+// interface or skips the test if there isn't one.
+func MulticastIPOrSkip(t testing.TB) string {
+	laddrs, err := ssdp.MulticastAddrs()
+	if err != nil {
+		t.Skipf("MulticastAddrs: %s", err)
+	}
+	for _, laddr := range laddrs {
+		if !ssdp.IsIPv6Addr(laddr) {
+			host, _, _ := net.SplitHostPort(laddr)
+			return host
+		}
+	}
+	t.Skip("no multicast interface")
+	return ""
+}
@@ -0,0 +1,559 @@
+// See license file for copyright and license details.
+
+// This file implements the events of a bind session: the bind channel is kept
+// open with long-poll requests and the messages sent by the screen are
+// delivered as typed Events.
+
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	paramCi      = "0"
+	paramRidRpc  = "rpc"
+	paramTypeXhr = "xmlhttp"
+
+	eventsIdleTimeout = 2 * time.Minute // a long-poll request without data for this long is restarted.
+	eventsMinPoll     = 1 * time.Second // a long-poll request ending sooner without data is retried with backoff.
+	eventsMinBackoff  = 1 * time.Second
+	eventsMaxBackoff  = 30 * time.Second
+	requestTimeout    = 10 * time.Second // how long await() waits for the response Events.
+
+	// types of the messages handled internally.
+	msgInvalid    = "" // a malformed message, skipped.
+	msgSId        = "c"
+	msgGSessionId = "S"
+	msgNoop       = "noop"
+	msgStop       = "stop"
+)
+
+// Event types. Messages of other types are delivered too, with Args only.
+const (
+	EventNowPlaying          = "nowPlaying"            // the video (or nothing) being played, Player is set.
+	EventStateChange         = "onStateChange"         // the player changed state or position, Player is set (without ids).
+	EventVolumeChanged       = "onVolumeChanged"       // Volume is set.
+	EventPlaylistModified    = "playlistModified"      // the queue changed, Playlist is set.
+	EventAutoplayModeChanged = "onAutoplayModeChanged" // Autoplay is set.
+	EventLoungeStatus        = "loungeStatus"          // devices connected to the lounge, Devices is set.
+)
+
+var (
+	errBadMessage     = errors.New("invalid bind message")
+	errSessionExpired = errors.New("bind session expired")
+	errIdleStream     = errors.New("no data from long-poll request")
+	errEmptyPoll      = errors.New("long-poll request ended without data")
+	errNoResponse     = errors.New("no response from the tv app")
+	errResponse       = errors.New("response received") // stops poll() in await().
+)
+
+// State is the state of the player of the screen.
+type State int
+
+// Player states.
+const (
+	StateUnstarted State = -1
+	StateEnded     State = 0
+	StatePlaying   State = 1
+	StatePaused    State = 2
+	StateBuffering State = 3
+	StateCued      State = 5
+)
+
+func (s State) String() string {
+	switch s {
+	case StateUnstarted:
+		return "unstarted"
+	case StateEnded:
+		return "ended"
+	case StatePlaying:
+		return "playing"
+	case StatePaused:
+		return "paused"
+	case StateBuffering:
+		return "buffering"
+	case StateCued:
+		return "cued"
+	}
+	return "state(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Event is a message sent by the screen on a bind session.
+type Event struct {
+	Id       int               // message id, increasing within a bind session.
+	Type     string            // message type, e.g. EventNowPlaying.
+	Args     []json.RawMessage // message arguments as received (usually a single JSON object).
+	Player   *Player           // EventNowPlaying and EventStateChange only.
+	Volume   *Volume           // EventVolumeChanged only.
+	Playlist *Playlist         // EventPlaylistModified only.
+	Autoplay string            // EventAutoplayModeChanged only: ENABLED, DISABLED or UNSUPPORTED.
+	Devices  []LoungeDevice    // EventLoungeStatus only.
+}
+
+// Player is the state of the player of the screen.
+type Player struct {
+	VideoId      string // empty if nothing is being played or for EventStateChange.
+	ListId       string // id of the queue.
+	CurrentIndex int    // index of the video in the queue (-1 if unknown).
+	State        State
+	CurrentTime  time.Duration
+	Duration     time.Duration
+	LoadedTime   time.Duration
+}
+
+// Volume is the volume of the screen.
+type Volume struct {
+	Level int // from 0 to 100.
+	Muted bool
+}
+
+// Playlist is the queue of the screen.
+type Playlist struct {
+	ListId       string   // id of the queue.
+	VideoId      string   // video being played.
+	CurrentIndex int      // index of VideoId in the queue (-1 if unknown).
+	VideoIds     []string // videos in the queue, when sent by the screen.
+}
+
+// LoungeDevice is a device connected to the lounge of the screen (the screen
+// itself and the remotes).
+type LoungeDevice struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"` // LOUNGE_SCREEN or REMOTE_CONTROL.
+	App  string `json:"app"`
+}
+
+// Events sets up a new bind session and returns the channel where the Events
+// sent by the screen are delivered, starting with the ones describing its
+// current state (e.g. EventNowPlaying). The session is kept open with long-poll
+// requests and it's transparently set up again when it expires (refreshing the
+// LoungeToken if needed). The channel is closed when ctx is done.
+// The session belongs to a copy of the Remote, so the Remote can still be used
+// (e.g. to Play()) while Events are received.
+func (r *Remote) Events(ctx context.Context) (chan *Event, error) {
+	rc := *r
+	ch := make(chan *Event)
+	s := newEventStream(&rc, func(ctx context.Context, ev *Event) error {
+		select {
+		case ch <- ev:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	msgs, err := s.bind(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getSessionIds: %w", err)
+	}
+	go func() {
+		defer close(ch)
+		s.run(ctx, msgs)
+	}()
+	return ch, nil
+}
+
+// eventStream receives the Events of the bind session of r.
+type eventStream struct {
+	r      *Remote
+	hc     *http.Client                        // like r.httpClient, but without timeout.
+	aId    int                                 // id of the last message received.
+	handle func(context.Context, *Event) error // called for each Event, an error stops poll().
+}
+
+func newEventStream(r *Remote, handle func(context.Context, *Event) error) *eventStream {
+	hc := *r.httpClient
+	hc.Timeout = 0 // long-poll requests are restarted by eventsIdleTimeout.
+	return &eventStream{r: r, hc: &hc, aId: r.aId, handle: handle}
+}
+
+// request sends the command name with args to the tv app and waits for the
+// first Event of type typ it sends back on the same bind session.
+func (r *Remote) request(ctx context.Context, name string, args url.Values, typ string) (*Event, error) {
+	if err := r.sendCommand(ctx, name, args); err != nil {
+		return nil, err
+	}
+	var resp *Event
+	err := r.await(ctx, func(ev *Event) bool {
+		if ev.Type == typ {
+			resp = ev
+		}
+		return resp != nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return resp, nil
+}
+
+// await receives the Events of the current bind session, passing them to done
+// until it returns true. It gives up with errNoResponse after requestTimeout.
+func (r *Remote) await(ctx context.Context, done func(*Event) bool) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, requestTimeout, errNoResponse)
+	defer cancel()
+	s := newEventStream(r, func(ctx context.Context, ev *Event) error {
+		if done(ev) {
+			return errResponse
+		}
+		return nil
+	})
+	defer func() { r.aId = s.aId }() // the Events received won't be received again.
+	for {
+		err := s.pollData(ctx)
+		if errors.Is(err, errResponse) {
+			return nil
+		}
+		if errors.Is(err, errEmptyPoll) {
+			err = sleep(ctx, eventsMinBackoff)
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx) // errNoResponse or the error of the parent ctx.
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// run delivers msgs and then keeps the session open (setting up a new one if
+// it expires) until ctx is done.
+func (s *eventStream) run(ctx context.Context, msgs []*message) {
+	var backoff time.Duration
+	for {
+		var err error
+		for _, m := range msgs {
+			if err = s.deliver(ctx, m); err != nil {
+				break
+			}
+		}
+		msgs = nil
+		if err == nil {
+			if s.r.SId == "" {
+				msgs, err = s.bind(ctx)
+			} else {
+				err = s.pollData(ctx)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			backoff = 0
+			continue
+		}
+		if errors.Is(err, errSessionExpired) {
+			s.r.SId, s.r.GSessionId = "", ""
+			if time.Since(s.r.bound) > sessionMaxAge {
+				log.Printf("Events: %s, setting up a new one", err)
+				continue
+			}
+			// new sessions expiring immediately, don't loop.
+		}
+		backoff = min(max(2*backoff, eventsMinBackoff), eventsMaxBackoff)
+		log.Printf("Events: %s, retrying in %s", err, backoff)
+		if sleep(ctx, backoff) != nil {
+			return
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done, in which case it returns ctx.Err().
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bind sets up a new bind session, refreshing the LoungeToken if it has
+// expired.
+func (s *eventStream) bind(ctx context.Context) ([]*message, error) {
+	if s.r.Expired() {
+		if err := s.r.RefreshTokenContext(ctx); err != nil {
+			return nil, fmt.Errorf("RefreshToken: %w", err)
+		}
+	}
+	msgs, err := s.r.getSessionIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.aId = s.r.aId
+	return msgs, nil
+}
+
+// poll sends a long-poll request on the bind session and delivers the messages
+// received until the response ends (normally after a few minutes).
+func (s *eventStream) poll(ctx context.Context) error {
+	q := url.Values{}
+	q.Set("AID", strconv.Itoa(s.aId))
+	q.Set("CI", paramCi)
+	q.Set("CVER", paramCver)
+	q.Set("RID", paramRidRpc)
+	q.Set("SID", s.r.SId)
+	q.Set("TYPE", paramTypeXhr)
+	q.Set("VER", paramVer)
+	q.Set("app", paramApp)
+	q.Set("device", paramDevice)
+	q.Set("gsessionid", s.r.GSessionId)
+	q.Set("id", paramId)
+	q.Set("loungeIdToken", s.r.LoungeToken)
+	q.Set("name", s.r.Name)
+
+	pollCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	idle := time.AfterFunc(eventsIdleTimeout, func() { cancel(errIdleStream) })
+	defer idle.Stop()
+
+	req, err := http.NewRequestWithContext(pollCtx, "GET", apiBind, nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Origin", Origin)
+	req.Header.Set("User-Agent", userAgent)
+
+	log.Printf("GET %s (long-poll)", apiBind)
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return idleOr(pollCtx, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone:
+		// e.g. 400 Unknown SID.
+		return fmt.Errorf("GET %s: %s: %w", apiBind, resp.Status, errSessionExpired)
+	default:
+		return fmt.Errorf("GET %s: %s: %w", apiBind, resp.Status, errBadHttpStatus)
+	}
+
+	body := &idleReader{r: resp.Body, t: idle}
+	err = readMessages(body, func(m *message) error {
+		if m.typ == msgStop {
+			return errSessionExpired
+		}
+		return s.deliver(ctx, m) // not pollCtx, the message would get lost.
+	})
+	return idleOr(pollCtx, err)
+}
+
+// pollData is like poll(), but it returns errEmptyPoll if the long-poll request
+// ended without data in less than eventsMinPoll (e.g. the response is empty),
+// so that it's not sent again right away.
+func (s *eventStream) pollData(ctx context.Context) error {
+	start, aId := time.Now(), s.aId
+	err := s.poll(ctx)
+	if err == nil && s.aId == aId && time.Since(start) < eventsMinPoll {
+		return errEmptyPoll
+	}
+	return err
+}
+
+// idleOr returns nil if pollCtx was canceled because the long-poll request was
+// idle (it's restarted as if it ended normally), err otherwise.
+func idleOr(pollCtx context.Context, err error) error {
+	if errors.Is(context.Cause(pollCtx), errIdleStream) {
+		return nil
+	}
+	return err
+}
+
+// deliver sends m to the channel as an Event, unless it's handled internally.
+func (s *eventStream) deliver(ctx context.Context, m *message) error {
+	s.aId = max(s.aId, m.id)
+	switch m.typ {
+	case msgInvalid:
+		return nil
+	case msgSId:
+		s.r.SId = m.str(0)
+		return nil
+	case msgGSessionId:
+		s.r.GSessionId = m.str(0)
+		return nil
+	case msgNoop:
+		return nil
+	}
+	return s.handle(ctx, newEvent(m))
+}
+
+// idleReader resets t to eventsIdleTimeout each time data is read from r.
+type idleReader struct {
+	r io.Reader
+	t *time.Timer
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.t.Reset(eventsIdleTimeout)
+	}
+	return n, err
+}
+
+// message is a message of a bind session.
+type message struct {
+	id   int
+	typ  string
+	args []json.RawMessage
+}
+
+// str returns the i-th argument of m if it's a string, "" otherwise.
+func (m *message) str(i int) string {
+	var s string
+	if i < len(m.args) {
+		json.Unmarshal(m.args[i], &s)
+	}
+	return s
+}
+
+// readMessages reads the messages of a bind session from r and calls fn for
+// each of them, until r ends or fn returns an error. Malformed messages are
+// logged and passed to fn with type msgInvalid (and their id, if any), so that
+// they are not received again. Messages come in chunks:
+// each chunk is a JSON array of messages preceded by its length (which is
+// skipped), each message is an array with the id and another array with the
+// type followed by the arguments, e.g.
+//
+//	59
+//	[[7,["onVolumeChanged",{"volume":"40","muted":"false"}]]
+//	]
+func readMessages(r io.Reader, fn func(*message) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var chunk json.RawMessage
+		if err := dec.Decode(&chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if chunk[0] != '[' {
+			continue // length.
+		}
+		var raws []json.RawMessage
+		if err := json.Unmarshal(chunk, &raws); err != nil {
+			return err
+		}
+		for _, raw := range raws {
+			m, err := parseMessage(raw)
+			if err != nil {
+				log.Println(err) // m is msgInvalid.
+			}
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseMessage parses a message of a bind session. On error, the returned
+// message is msgInvalid with only the id (0 if unknown).
+func parseMessage(data []byte) (*message, error) {
+	m := &message{}
+	var v []json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil || len(v) == 0 {
+		return m, fmt.Errorf("%s: %w", data, errBadMessage)
+	}
+	if err := json.Unmarshal(v[0], &m.id); err != nil {
+		return m, fmt.Errorf("%s: %w", data, errBadMessage)
+	}
+	var body []json.RawMessage
+	if len(v) < 2 || json.Unmarshal(v[1], &body) != nil || len(body) == 0 {
+		return &message{id: m.id}, fmt.Errorf("%s: %w", data, errBadMessage)
+	}
+	if err := json.Unmarshal(body[0], &m.typ); err != nil || m.typ == msgInvalid {
+		return &message{id: m.id}, fmt.Errorf("%s: %w", data, errBadMessage)
+	}
+	m.args = body[1:]
+	return m, nil
+}
+
+// newEvent returns m as an Event, with the typed fields set according to its
+// type.
+func newEvent(m *message) *Event {
+	ev := &Event{Id: m.id, Type: m.typ, Args: m.args}
+	var f fields
+	if len(m.args) > 0 {
+		json.Unmarshal(m.args[0], &f) // if not an object, typed fields get the zero values.
+	}
+	switch m.typ {
+	case EventNowPlaying, EventStateChange:
+		ev.Player = &Player{
+			VideoId:      f.str("videoId"),
+			ListId:       f.str("listId"),
+			CurrentIndex: f.atoi("currentIndex", -1),
+			State:        State(f.atoi("state", int(StateUnstarted))),
+			CurrentTime:  f.seconds("currentTime"),
+			Duration:     f.seconds("duration"),
+			LoadedTime:   f.seconds("loadedTime"),
+		}
+	case EventVolumeChanged:
+		ev.Volume = &Volume{Level: f.atoi("volume", 0), Muted: f.str("muted") == "true"}
+	case EventPlaylistModified:
+		ev.Playlist = &Playlist{
+			ListId:       f.str("listId"),
+			VideoId:      f.str("videoId"),
+			CurrentIndex: f.atoi("currentIndex", -1),
+		}
+		if ids := f.str("videoIds"); ids != "" {
+			ev.Playlist.VideoIds = strings.Split(ids, ",")
+		}
+	case EventAutoplayModeChanged:
+		ev.Autoplay = f.str("autoplayMode")
+	case EventLoungeStatus:
+		// devices is a JSON array encoded in a string.
+		if err := json.Unmarshal([]byte(f.str("devices")), &ev.Devices); err != nil {
+			ev.Devices = nil
+		}
+	}
+	return ev
+}
+
+// fields are the arguments of a message as a JSON object. The Lounge sends
+// (almost) every value as a string.
+type fields map[string]any
+
+func (f fields) str(k string) string {
+	switch v := f[k].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// atoi returns the value of k as an int or def if missing or invalid.
+func (f fields) atoi(k string, def int) int {
+	n, err := strconv.Atoi(f.str(k))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// seconds returns the value of k (seconds, possibly fractional) as a
+// time.Duration or 0 if missing or invalid.
+func (f fields) seconds(k string) time.Duration {
+	x, err := strconv.ParseFloat(f.str(k), 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(x * float64(time.Second))
+}
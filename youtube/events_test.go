@@ -0,0 +1,297 @@
+// See license file for copyright and license details.
+
+package youtube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadMessages(t *testing.T) {
+	tests := []struct {
+		data  string
+		ids   []int
+		types []string
+		err   bool
+	}{
+		{
+			data: `
+97
+[[12,["onStateChange",{"currentTime":"12.5","state":"2"}]]
+,[13,["onVolumeChanged",{"volume":"30","muted":"false"}]]
+]
+25
+[[14,["noop"]]
+]
+`,
+			ids:   []int{12, 13, 14},
+			types: []string{"onStateChange", "onVolumeChanged", "noop"},
+		},
+		{
+			data: "",
+		},
+		{
+			// malformed messages are skipped, but their ids are kept.
+			data:  chunk(`[1]`, `[2,[]]`, `["3",["noop"]]`, `[4,[""]]`, `[5,["noop"]]`),
+			ids:   []int{1, 2, 0, 4, 5},
+			types: []string{msgInvalid, msgInvalid, msgInvalid, msgInvalid, "noop"},
+		},
+		{
+			data: chunk(`[1,[]`), // invalid chunk.
+			err:  true,
+		},
+	}
+
+	for i, test := range tests {
+		var ids []int
+		var types []string
+		err := readMessages(strings.NewReader(test.data), func(m *message) error {
+			ids = append(ids, m.id)
+			types = append(types, m.typ)
+			return nil
+		})
+		if (err != nil) != test.err {
+			t.Fatalf("tests[%d]: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(test.ids, ids) {
+			t.Fatalf("tests[%d]: ids: want %v got %v", i, test.ids, ids)
+		}
+		if !reflect.DeepEqual(test.types, types) {
+			t.Fatalf("tests[%d]: types: want %q got %q", i, test.types, types)
+		}
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	tests := []struct {
+		data string
+		want *Event
+	}{
+		{
+			data: `[1,["nowPlaying",{"currentTime":"5.5","duration":"213","loadedTime":"20","videoId":"dQw4w9WgXcQ","state":"1","listId":"RQfoo","currentIndex":"0"}]]`,
+			want: &Event{Id: 1, Type: EventNowPlaying, Player: &Player{
+				VideoId:      "dQw4w9WgXcQ",
+				ListId:       "RQfoo",
+				CurrentIndex: 0,
+				State:        StatePlaying,
+				CurrentTime:  5500 * time.Millisecond,
+				Duration:     213 * time.Second,
+				LoadedTime:   20 * time.Second,
+			}},
+		},
+		{
+			data: `[2,["nowPlaying",{}]]`,
+			want: &Event{Id: 2, Type: EventNowPlaying, Player: &Player{CurrentIndex: -1, State: StateUnstarted}},
+		},
+		{
+			data: `[3,["onStateChange",{"currentTime":"60","duration":"213","state":"2"}]]`,
+			want: &Event{Id: 3, Type: EventStateChange, Player: &Player{
+				CurrentIndex: -1,
+				State:        StatePaused,
+				CurrentTime:  time.Minute,
+				Duration:     213 * time.Second,
+			}},
+		},
+		{
+			data: `[4,["onVolumeChanged",{"volume":"40","muted":"true"}]]`,
+			want: &Event{Id: 4, Type: EventVolumeChanged, Volume: &Volume{Level: 40, Muted: true}},
+		},
+		{
+			data: `[5,["playlistModified",{"currentIndex":"1","listId":"RQfoo","videoId":"cdKop6aixVE","videoIds":"dQw4w9WgXcQ,cdKop6aixVE"}]]`,
+			want: &Event{Id: 5, Type: EventPlaylistModified, Playlist: &Playlist{
+				ListId:       "RQfoo",
+				VideoId:      "cdKop6aixVE",
+				CurrentIndex: 1,
+				VideoIds:     []string{"dQw4w9WgXcQ", "cdKop6aixVE"},
+			}},
+		},
+		{
+			data: `[6,["onAutoplayModeChanged",{"autoplayMode":"UNSUPPORTED"}]]`,
+			want: &Event{Id: 6, Type: EventAutoplayModeChanged, Autoplay: "UNSUPPORTED"},
+		},
+		{
+			data: `[7,["loungeStatus",{"devices":"[{\"app\":\"lb-v4\",\"name\":\"YouTube on TV\",\"id\":\"screen-id\",\"type\":\"LOUNGE_SCREEN\"},{\"app\":\"youtube-desktop\",\"name\":\"ytcast\",\"id\":\"remote\",\"type\":\"REMOTE_CONTROL\"}]","queueId":"RQfoo"}]]`,
+			want: &Event{Id: 7, Type: EventLoungeStatus, Devices: []LoungeDevice{
+				{Id: "screen-id", Name: "YouTube on TV", Type: "LOUNGE_SCREEN", App: "lb-v4"},
+				{Id: "remote", Name: "ytcast", Type: "REMOTE_CONTROL", App: "youtube-desktop"},
+			}},
+		},
+		{
+			data: `[8,["onPlaylistModeChanged",{"shuffleEnabled":"false","loopEnabled":"false"}]]`,
+			want: &Event{Id: 8, Type: "onPlaylistModeChanged"},
+		},
+	}
+
+	for i, test := range tests {
+		m, err := parseMessage([]byte(test.data))
+		if err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		got := newEvent(m)
+		got.Args = nil // not compared.
+		if !reflect.DeepEqual(test.want, got) {
+			t.Fatalf("tests[%d]: want %+v got %+v", i, test.want, got)
+		}
+	}
+}
+
+// chunk returns msgs as a chunk of a bind session, see readMessages().
+func chunk(msgs ...string) string {
+	data := "[" + strings.Join(msgs, "\n,") + "\n]\n"
+	return fmt.Sprintf("%d\n%s", len(data), data)
+}
+
+func TestEventsRebind(t *testing.T) {
+	var mu sync.Mutex
+	binds := 0
+	polls := map[string]int{} // long-poll requests by SID.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		sId := q.Get("SID")
+		mu.Lock()
+		if sId == "" {
+			binds++
+			sId = fmt.Sprintf("sid%d", binds)
+		} else {
+			polls[sId]++
+		}
+		n := polls[sId]
+		mu.Unlock()
+
+		switch {
+		case r.Method == "POST" && q.Get("SID") == "":
+			fmt.Fprint(w, chunk(
+				`[0,["c","`+sId+`","",8]]`,
+				`[1,["S","gsession"]]`,
+				`[2,["nowPlaying",{"videoId":"`+sId+`","state":"1"}]]`,
+			))
+		case r.Method != "GET":
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+		case q.Get("AID") != "2" && n == 1:
+			t.Errorf("%s: AID: want 2 got %s", sId, q.Get("AID"))
+		case sId == "sid1" && n == 1:
+			fmt.Fprint(w, chunk(`[3,["onVolumeChanged",{"volume":"40","muted":"false"}]]`))
+		case sId == "sid1":
+			http.Error(w, "Unknown SID", http.StatusBadRequest) // expired.
+		default:
+			fmt.Fprint(w, chunk(`[3,["onStateChange",{"currentTime":"1","state":"2"}]]`))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done() // until the test is done.
+		}
+	}))
+	defer srv.Close()
+	defer func(orig string) { apiBind = orig }(apiBind)
+	apiBind = srv.URL
+
+	r := &Remote{
+		httpClient:  srv.Client(),
+		ScreenId:    "screen-id",
+		Name:        "TestEventsRebind",
+		LoungeToken: "token",
+		Expiration:  time.Now().Add(time.Hour).UnixMilli(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch, err := r.Events(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{
+		EventNowPlaying + " sid1",
+		EventVolumeChanged,
+		EventNowPlaying + " sid2", // after the session expired.
+		EventStateChange,
+	}
+	for i, w := range want {
+		ev, ok := <-ch
+		if !ok {
+			t.Fatalf("events[%d]: channel closed: %s", i, ctx.Err())
+		}
+		got := ev.Type
+		if ev.Type == EventNowPlaying {
+			got += " " + ev.Player.VideoId
+		}
+		if got != w {
+			t.Fatalf("events[%d]: want %q got %q", i, w, got)
+		}
+	}
+	cancel()
+	for range ch {
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if binds != 2 {
+		t.Fatalf("binds: want 2 got %d", binds)
+	}
+}
+
+func TestEventsBadMessage(t *testing.T) {
+	var mu sync.Mutex
+	var aIds []string // AID of the long-poll requests.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Method == "POST" {
+			fmt.Fprint(w, chunk(`[0,["c","sid","",8]]`, `[1,["S","gsession"]]`))
+			return
+		}
+		mu.Lock()
+		aIds = append(aIds, q.Get("AID"))
+		n := len(aIds)
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			fmt.Fprint(w, chunk(`[2,["noop"]]`, `[3,[]]`)) // malformed.
+		case 2:
+			// empty, retried after eventsMinBackoff.
+		case 3:
+			fmt.Fprint(w, chunk(`[4,["onVolumeChanged",{"volume":"40","muted":"false"}]]`))
+		default:
+			<-r.Context().Done() // until the test is done.
+		}
+	}))
+	defer srv.Close()
+	defer func(orig string) { apiBind = orig }(apiBind)
+	apiBind = srv.URL
+
+	r := &Remote{
+		httpClient:  srv.Client(),
+		ScreenId:    "screen-id",
+		Name:        "TestEventsBadMessage",
+		LoungeToken: "token",
+		Expiration:  time.Now().Add(time.Hour).UnixMilli(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch, err := r.Events(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	start := time.Now()
+	ev, ok := <-ch
+	if !ok {
+		t.Fatalf("channel closed: %s", ctx.Err())
+	}
+	if ev.Type != EventVolumeChanged || ev.Id != 4 {
+		t.Fatalf("want %s 4 got %s %d", EventVolumeChanged, ev.Type, ev.Id)
+	}
+	if elapsed := time.Since(start); elapsed < eventsMinBackoff {
+		t.Fatalf("empty long-poll retried after %s, want at least %s", elapsed, eventsMinBackoff)
+	}
+	cancel()
+	for range ch {
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"1", "3", "3"}; !reflect.DeepEqual(want, aIds[:min(len(aIds), 3)]) {
+		t.Fatalf("AIDs: want %q got %q", want, aIds)
+	}
+}
@@ -0,0 +1,73 @@
+// See license file for copyright and license details.
+
+package youtube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var errNoPlaylist = errors.New("the tv app didn't report the queue")
+
+// GetPlaylist sets up a new bind session and returns the queue of the tv app,
+// as reported when the session is set up. Some tv apps don't report the
+// videos in the queue (VideoIds is empty).
+func (r *Remote) GetPlaylist() (*Playlist, error) {
+	return r.GetPlaylistContext(context.Background())
+}
+
+// GetPlaylistContext is like GetPlaylist(), but accepts a context.Context.
+func (r *Remote) GetPlaylistContext(ctx context.Context) (*Playlist, error) {
+	msgs, err := r.getSessionIds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getSessionIds: %w", err)
+	}
+	var pl *Playlist
+	for _, m := range msgs {
+		if ev := newEvent(m); ev.Playlist != nil {
+			pl = ev.Playlist // the last one is the current one.
+		}
+	}
+	if pl == nil || pl.ListId == "" {
+		return nil, errNoPlaylist
+	}
+	return pl, nil
+}
+
+// RemoveVideo requests the Lounge API to remove video from the queue of the tv
+// app and returns the queue as modified. Accepts both video urls and video ids.
+func (r *Remote) RemoveVideo(video string) (*Playlist, error) {
+	return r.RemoveVideoContext(context.Background(), video)
+}
+
+// RemoveVideoContext is like RemoveVideo(), but accepts a context.Context.
+func (r *Remote) RemoveVideoContext(ctx context.Context, video string) (*Playlist, error) {
+	id, _ := extractVideoInfo(video)
+	args := url.Values{}
+	args.Set("videoId", id)
+	return r.modifyPlaylist(ctx, "removeVideo", args)
+}
+
+// ClearPlaylist requests the Lounge API to clear the queue of the tv app and
+// returns the queue as modified.
+func (r *Remote) ClearPlaylist() (*Playlist, error) {
+	return r.ClearPlaylistContext(context.Background())
+}
+
+// ClearPlaylistContext is like ClearPlaylist(), but accepts a context.Context.
+func (r *Remote) ClearPlaylistContext(ctx context.Context) (*Playlist, error) {
+	return r.modifyPlaylist(ctx, "clearPlaylist", nil)
+}
+
+// modifyPlaylist sends the command name with args to the tv app and returns
+// the queue as modified, read back from the EventPlaylistModified sent by the
+// tv app.
+func (r *Remote) modifyPlaylist(ctx context.Context, name string, args url.Values) (*Playlist, error) {
+	ev, err := r.request(ctx, name, args, EventPlaylistModified)
+	if err != nil {
+		return nil, err
+	}
+	return ev.Playlist, nil
+}
@@ -17,6 +17,8 @@
 package youtube
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -34,7 +36,6 @@ const (
 	apiBase           = "https://www.youtube.com/api/lounge"
 	apiGetLoungeToken = apiBase + "/pairing/get_lounge_token_batch"
 	apiGetScreen      = apiBase + "/pairing/get_screen"
-	apiBind           = apiBase + "/bc/bind"
 
 	paramApp              = "youtube-desktop"
 	paramCver             = "1"
@@ -44,7 +45,9 @@ const (
 	paramRidPlay          = "2"
 	paramVer              = "8"
 
-	httpTimeout = 30 * time.Second
+	httpTimeout   = 30 * time.Second
+	sessionMaxAge = 10 * time.Second // SId and GSessionId older than this are fetched again.
+
 	reqMinDelay = 2 * time.Second
 	reqMaxDelay = reqMinDelay + 3*time.Second
 
@@ -59,6 +62,9 @@ const (
 	DialAppName = "YouTube"
 )
 
+// apiBind is a variable so that tests can bind to an httptest.Server.
+var apiBind = apiBase + "/bc/bind"
+
 var (
 	errBadHttpStatus = errors.New("bad HTTP response status")
 	errNoScreenId    = errors.New("missing screenId")
@@ -72,12 +78,14 @@ var (
 type Remote struct {
 	localAddr  string       // localAddr is the local address the Remote instance must use for network operations.
 	httpClient *http.Client // httpClient is an http.Client setup to use localAddr.
+	bound      time.Time    // when SId and GSessionId were fetched.
+	aId        int          // id of the last message received on the bind session.
 
 	ScreenId    string // id of the screen (tv app) we are connected (or connecting) to.
 	Name        string // name displayed on the screen at connection time.
 	LoungeToken string // token for Lounge API requests.
 	Expiration  int64  // LoungeToken expiration timestamp in milliseconds.
-	SId         string // session id? it can expire very often so we fetch it at each Play() or Add().
+	SId         string // session id? it can expire very often so we fetch it at each Play(), Add(), Pause(), etc. (unless just fetched by Bind()).
 	GSessionId  string // another session id? google session id? we fetch it along with SId.
 
 	// these fields are present ONLY if connected with code (ConnectWithCode()).
@@ -89,11 +97,16 @@ type Remote struct {
 // Lounge API. name will be displayed on the screen at connection time. Returns
 // a Remote that can be used to play video on that screen.
 func Connect(localAddr, screenId, name string) (*Remote, error) {
+	return ConnectContext(context.Background(), localAddr, screenId, name)
+}
+
+// ConnectContext is like Connect(), but accepts a context.Context.
+func ConnectContext(ctx context.Context, localAddr, screenId, name string) (*Remote, error) {
 	r := &Remote{ScreenId: screenId, Name: name}
 	if err := r.SetLocalAddr(localAddr); err != nil {
 		return nil, fmt.Errorf("SetLocalAddr: %w", err)
 	}
-	if err := r.RefreshToken(); err != nil {
+	if err := r.RefreshTokenContext(ctx); err != nil {
 		return nil, fmt.Errorf("RefreshToken: %w", err)
 	}
 	return r, nil
@@ -102,13 +115,19 @@ func Connect(localAddr, screenId, name string) (*Remote, error) {
 // ConnectWithCode is like Connect(), but uses a pairing code (generated by the
 // tv app) to get ScreenId and LoungeToken.
 func ConnectWithCode(localAddr, code, name string) (*Remote, error) {
+	return ConnectWithCodeContext(context.Background(), localAddr, code, name)
+}
+
+// ConnectWithCodeContext is like ConnectWithCode(), but accepts a
+// context.Context.
+func ConnectWithCodeContext(ctx context.Context, localAddr, code, name string) (*Remote, error) {
 	hc, err := newHTTPClient(localAddr)
 	if err != nil {
 		return nil, err
 	}
 	q := url.Values{}
 	q.Set("pairing_code", removeSpaces(code))
-	respBody, err := doReq(hc, "GET", apiGetScreen, q, nil)
+	respBody, err := doReq(ctx, hc, "GET", apiGetScreen, q, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -192,9 +211,14 @@ func (r *Remote) SetLocalAddr(localAddr string) error {
 // RefreshToken gets a new LoungeToken for the screenId. Should be used when the
 // token has Expired().
 func (r *Remote) RefreshToken() error {
+	return r.RefreshTokenContext(context.Background())
+}
+
+// RefreshTokenContext is like RefreshToken(), but accepts a context.Context.
+func (r *Remote) RefreshTokenContext(ctx context.Context) error {
 	b := url.Values{}
 	b.Set("screen_ids", r.ScreenId)
-	respBody, err := doReq(r.httpClient, "POST", apiGetLoungeToken, nil, b)
+	respBody, err := doReq(ctx, r.httpClient, "POST", apiGetLoungeToken, nil, b)
 	if err != nil {
 		return err
 	}
@@ -231,7 +255,35 @@ func (r *Remote) Expired() bool {
 	return time.Now().After(exp)
 }
 
-func (r *Remote) getSessionIds() error {
+// Bind sets up a new bind session, i.e. fetches new SId and GSessionId. It's
+// done by Play() and Add() anyway, but it can be done in advance (e.g. while
+// waiting for something else) to save a request: Play() and Add() reuse a
+// session set up less than a few seconds before.
+func (r *Remote) Bind() error {
+	return r.BindContext(context.Background())
+}
+
+// BindContext is like Bind(), but accepts a context.Context.
+func (r *Remote) BindContext(ctx context.Context) error {
+	if _, err := r.getSessionIds(ctx); err != nil {
+		return fmt.Errorf("getSessionIds: %w", err)
+	}
+	return nil
+}
+
+// ensureSession sets up a new bind session unless the current one has just
+// been set up.
+func (r *Remote) ensureSession(ctx context.Context) error {
+	if r.SId != "" && r.GSessionId != "" && time.Since(r.bound) < sessionMaxAge {
+		return nil
+	}
+	return r.BindContext(ctx)
+}
+
+// getSessionIds sets up a new bind session and returns the other messages
+// received with the session ids (they describe the current state of the
+// screen, see Events()).
+func (r *Remote) getSessionIds(ctx context.Context) ([]*message, error) {
 	q := url.Values{}
 	q.Set("CVER", paramCver)
 	q.Set("RID", paramRidGetSessionIds)
@@ -241,110 +293,88 @@ func (r *Remote) getSessionIds() error {
 	q.Set("id", paramId)
 	q.Set("loungeIdToken", r.LoungeToken)
 	q.Set("name", r.Name)
-	respBody, err := doReq(r.httpClient, "POST", apiBind, q, nil)
+	respBody, err := doReq(ctx, r.httpClient, "POST", apiBind, q, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	sId, gSessionId, err := extractSessionIds(respBody)
+	sId, gSessionId, aId, msgs, err := extractSessionIds(respBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	r.SId, r.GSessionId = sId, gSessionId
-	return nil
+	r.SId, r.GSessionId, r.bound, r.aId = sId, gSessionId, time.Now(), aId
+	return msgs, nil
 }
 
-func extractSessionIds(data []byte) (string, string, error) {
-	// first thing we get is a number that we can safely skip (I think it's
-	// payload length).
-	for i, c := range data {
-		if c == '[' {
-			data = data[i:]
-			break
-		}
-	}
-
-	// next we have a bunch of json arrays containing mixed type values,
-	// that's why interface{}. See remote_test.go for an example.
-	var v [][]interface{}
-	if err := json.Unmarshal(data, &v); err != nil {
-		return "", "", err
-	}
+// extractSessionIds extracts the session ids from the messages received when
+// setting up a bind session (see readMessages() and remote_test.go for an
+// example) and returns the id of the last message and the other messages too
+// (malformed ones excluded).
+func extractSessionIds(data []byte) (string, string, int, []*message, error) {
 	var sId string
-	var gsessionId string
-	for _, a1 := range v {
-		if len(a1) < 2 {
-			continue
-		}
-		a2, ok := a1[1].([]interface{})
-		if !ok || len(a2) < 2 {
-			continue
-		}
-
-		var key, value string
-		if key, ok = a2[0].(string); !ok {
-			continue
-		}
-		if value, ok = a2[1].(string); !ok {
-			continue
-		}
-		switch key {
-		case "c":
-			sId = value
-		case "S":
-			gsessionId = value
+	var gSessionId string
+	var aId int
+	var msgs []*message
+	err := readMessages(bytes.NewReader(data), func(m *message) error {
+		aId = max(aId, m.id)
+		switch m.typ {
+		case msgInvalid:
+		case msgSId:
+			sId = m.str(0)
+		case msgGSessionId:
+			gSessionId = m.str(0)
 		default:
-			continue
-		}
-
-		if sId != "" && gsessionId != "" {
-			return sId, gsessionId, nil
+			msgs = append(msgs, m)
 		}
+		return nil
+	})
+	if err != nil {
+		return "", "", 0, nil, err
 	}
-	return "", "", errNoSessionIds
+	if sId == "" || gSessionId == "" {
+		return "", "", 0, nil, errNoSessionIds
+	}
+	return sId, gSessionId, aId, msgs, nil
 }
 
 // Play requests the Lounge API to play immediately the first video on the
 // tv app and to enqueue the others. Accepts both video urls and video ids.
 func (r *Remote) Play(videos []string) error {
+	return r.PlayContext(context.Background(), videos)
+}
+
+// PlayContext is like Play(), but accepts a context.Context.
+func (r *Remote) PlayContext(ctx context.Context, videos []string) error {
 	if len(videos) == 0 {
 		return nil
 	}
-	if err := r.getSessionIds(); err != nil {
-		return fmt.Errorf("getSessionIds: %w", err)
-	}
-	q := url.Values{}
-	q.Set("CVER", paramCver)
-	q.Set("RID", paramRidPlay)
-	q.Set("SID", r.SId)
-	q.Set("VER", paramVer)
-	q.Set("gsessionid", r.GSessionId)
-	q.Set("loungeIdToken", r.LoungeToken)
-	b := url.Values{}
-	b.Set("count", "1")
-	b.Set("req0__sc", "setPlaylist")
 	// start time can be set only for the first video.
 	id, startTime := extractVideoInfo(videos[0])
-	b.Set("req0_videoId", id)
-	b.Set("req0_currentTime", strconv.FormatInt(int64(startTime.Seconds()), 10))
-	b.Set("req0_currentIndex", "0")
 	var videoIds []string
 	for _, v := range videos {
 		id, _ := extractVideoInfo(v)
 		videoIds = append(videoIds, id)
 	}
-	b.Set("req0_videoIds", strings.Join(videoIds, ","))
-	_, err := doReq(r.httpClient, "POST", apiBind, q, b)
-	return err
+	args := url.Values{}
+	args.Set("videoId", id)
+	args.Set("currentTime", strconv.FormatInt(int64(startTime.Seconds()), 10))
+	args.Set("currentIndex", "0")
+	args.Set("videoIds", strings.Join(videoIds, ","))
+	return r.sendCommand(ctx, "setPlaylist", args)
 }
 
 // Add requests the Lounge API to add videos to the queue without changing
 // what's currently playing on the tv app. Accepts both video urls and video ids.
 func (r *Remote) Add(videos []string) error {
+	return r.AddContext(context.Background(), videos)
+}
+
+// AddContext is like Add(), but accepts a context.Context.
+func (r *Remote) AddContext(ctx context.Context, videos []string) error {
 	if len(videos) == 0 {
 		return nil
 	}
-	if err := r.getSessionIds(); err != nil {
-		return fmt.Errorf("getSessionIds: %w", err)
+	if err := r.ensureSession(ctx); err != nil {
+		return err
 	}
 	q := url.Values{}
 	q.Set("CVER", paramCver)
@@ -358,21 +388,147 @@ func (r *Remote) Add(videos []string) error {
 		// request for each video, but without this random delay the
 		// queue may get messed up and some video may get "lost". also,
 		// each reqX_ needs to have its own index for the same reason.
-		randDelay(reqMinDelay, reqMaxDelay)
+		if err := randDelay(ctx, reqMinDelay, reqMaxDelay); err != nil {
+			return err
+		}
 		b := url.Values{}
 		b.Set("count", "1")
 		b.Set(fmt.Sprintf("req%d__sc", i), "addVideo")
 		id, _ := extractVideoInfo(v)
 		b.Set(fmt.Sprintf("req%d_videoId", i), id)
-		if _, err := doReq(r.httpClient, "POST", apiBind, q, b); err != nil {
+		if _, err := doReq(ctx, r.httpClient, "POST", apiBind, q, b); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func doReq(httpClient *http.Client, method, url string, query, body url.Values) ([]byte, error) {
-	req, err := http.NewRequest(method, url, strings.NewReader(body.Encode()))
+// Pause requests the Lounge API to pause the video playing on the tv app.
+func (r *Remote) Pause() error {
+	return r.PauseContext(context.Background())
+}
+
+// PauseContext is like Pause(), but accepts a context.Context.
+func (r *Remote) PauseContext(ctx context.Context) error {
+	return r.sendCommand(ctx, "pause", nil)
+}
+
+// Resume requests the Lounge API to resume the video paused on the tv app.
+func (r *Remote) Resume() error {
+	return r.ResumeContext(context.Background())
+}
+
+// ResumeContext is like Resume(), but accepts a context.Context.
+func (r *Remote) ResumeContext(ctx context.Context) error {
+	return r.sendCommand(ctx, "play", nil)
+}
+
+// SeekTo requests the Lounge API to seek the video playing on the tv app to
+// position t (truncated to seconds).
+func (r *Remote) SeekTo(t time.Duration) error {
+	return r.SeekToContext(context.Background(), t)
+}
+
+// SeekToContext is like SeekTo(), but accepts a context.Context.
+func (r *Remote) SeekToContext(ctx context.Context, t time.Duration) error {
+	args := url.Values{}
+	args.Set("newTime", strconv.FormatInt(int64(max(t, 0).Seconds()), 10))
+	return r.sendCommand(ctx, "seekTo", args)
+}
+
+// Next requests the Lounge API to play the next video in the queue.
+func (r *Remote) Next() error {
+	return r.NextContext(context.Background())
+}
+
+// NextContext is like Next(), but accepts a context.Context.
+func (r *Remote) NextContext(ctx context.Context) error {
+	return r.sendCommand(ctx, "next", nil)
+}
+
+// Previous requests the Lounge API to play the previous video in the queue.
+func (r *Remote) Previous() error {
+	return r.PreviousContext(context.Background())
+}
+
+// PreviousContext is like Previous(), but accepts a context.Context.
+func (r *Remote) PreviousContext(ctx context.Context) error {
+	return r.sendCommand(ctx, "previous", nil)
+}
+
+// SetVolume requests the Lounge API to set the volume of the tv app to level,
+// from 0 to 100.
+func (r *Remote) SetVolume(level int) error {
+	return r.SetVolumeContext(context.Background(), level)
+}
+
+// SetVolumeContext is like SetVolume(), but accepts a context.Context.
+func (r *Remote) SetVolumeContext(ctx context.Context, level int) error {
+	args := url.Values{}
+	args.Set("volume", strconv.Itoa(min(max(level, 0), 100)))
+	return r.sendCommand(ctx, "setVolume", args)
+}
+
+// Mute requests the Lounge API to mute the tv app.
+func (r *Remote) Mute() error {
+	return r.MuteContext(context.Background())
+}
+
+// MuteContext is like Mute(), but accepts a context.Context.
+func (r *Remote) MuteContext(ctx context.Context) error {
+	return r.sendCommand(ctx, "mute", nil)
+}
+
+// Unmute requests the Lounge API to unmute the tv app.
+func (r *Remote) Unmute() error {
+	return r.UnmuteContext(context.Background())
+}
+
+// UnmuteContext is like Unmute(), but accepts a context.Context.
+func (r *Remote) UnmuteContext(ctx context.Context) error {
+	return r.sendCommand(ctx, "unMute", nil)
+}
+
+// GetVolume asks the tv app its current volume, which is sent back as an
+// EventVolumeChanged.
+func (r *Remote) GetVolume() (*Volume, error) {
+	return r.GetVolumeContext(context.Background())
+}
+
+// GetVolumeContext is like GetVolume(), but accepts a context.Context.
+func (r *Remote) GetVolumeContext(ctx context.Context) (*Volume, error) {
+	ev, err := r.request(ctx, "getVolume", nil, EventVolumeChanged)
+	if err != nil {
+		return nil, err
+	}
+	return ev.Volume, nil
+}
+
+// sendCommand sends the command name with args to the tv app through a bind
+// session (set up if needed).
+func (r *Remote) sendCommand(ctx context.Context, name string, args url.Values) error {
+	if err := r.ensureSession(ctx); err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("CVER", paramCver)
+	q.Set("RID", paramRidPlay)
+	q.Set("SID", r.SId)
+	q.Set("VER", paramVer)
+	q.Set("gsessionid", r.GSessionId)
+	q.Set("loungeIdToken", r.LoungeToken)
+	b := url.Values{}
+	b.Set("count", "1")
+	b.Set("req0__sc", name)
+	for k, v := range args {
+		b["req0_"+k] = v
+	}
+	_, err := doReq(ctx, r.httpClient, "POST", apiBind, q, b)
+	return err
+}
+
+func doReq(ctx context.Context, httpClient *http.Client, method, url string, query, body url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -3,6 +3,7 @@
 package youtube
 
 import (
+	"slices"
 	"testing"
 	"time"
 )
@@ -52,6 +53,27 @@ func TestPlayFromTimestamp(t *testing.T) {
 	}
 }
 
+func TestVolume(t *testing.T) {
+	r := connectOrSkip(t, "TestVolume", "") // put your screenId here
+	if err := r.SetVolume(30); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(2 * time.Second)
+	vol, err := r.GetVolume()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if vol.Level != 30 {
+		t.Fatalf("volume: want 30 got %d", vol.Level)
+	}
+	if err := r.Mute(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Unmute(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
 func TestConnectWithCode(t *testing.T) {
 	code := "" // put your TV code here
 	if code == "" {
@@ -181,6 +203,8 @@ func TestExtractSessionIds(t *testing.T) {
 		data       []byte
 		sId        string
 		gSessionId string
+		aId        int
+		msgTypes   []string
 	}{
 		{
 			data: []byte(`
@@ -194,11 +218,13 @@ func TestExtractSessionIds(t *testing.T) {
 ]`),
 			sId:        "sid-foo-bar-baz",
 			gSessionId: "gsessionid-foo-bar-baz",
+			aId:        5,
+			msgTypes:   []string{"loungeStatus", "playlistModified", "onAutoplayModeChanged", "onPlaylistModeChanged"},
 		},
 	}
 
 	for i, test := range tests {
-		sId, gSessionId, err := extractSessionIds(test.data)
+		sId, gSessionId, aId, msgs, err := extractSessionIds(test.data)
 		if err != nil {
 			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
 		}
@@ -208,5 +234,15 @@ func TestExtractSessionIds(t *testing.T) {
 		if test.gSessionId != gSessionId {
 			t.Fatalf("tests[%d]: gSessionId: want %q got %q", i, test.gSessionId, gSessionId)
 		}
+		if test.aId != aId {
+			t.Fatalf("tests[%d]: aId: want %d got %d", i, test.aId, aId)
+		}
+		var msgTypes []string
+		for _, m := range msgs {
+			msgTypes = append(msgTypes, m.typ)
+		}
+		if !slices.Equal(test.msgTypes, msgTypes) {
+			t.Fatalf("tests[%d]: msgTypes: want %q got %q", i, test.msgTypes, msgTypes)
+		}
 	}
 }
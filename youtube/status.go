@@ -0,0 +1,84 @@
+// See license file for copyright and license details.
+
+package youtube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Status is what the tv app is playing, kept up to date with Update().
+type Status struct {
+	Player   *Player // nil if unknown.
+	Volume   *Volume // nil if unknown.
+	Autoplay string  // empty if unknown.
+}
+
+// Update updates s with ev and returns true if s changed. Events which don't
+// describe the status are ignored.
+func (s *Status) Update(ev *Event) bool {
+	switch {
+	case ev.Player != nil:
+		p := *ev.Player
+		if ev.Type == EventStateChange && s.Player != nil {
+			// the video is the same, only its state changed.
+			p.VideoId, p.ListId, p.CurrentIndex = s.Player.VideoId, s.Player.ListId, s.Player.CurrentIndex
+		}
+		if s.Player != nil && *s.Player == p {
+			return false
+		}
+		s.Player = &p
+	case ev.Volume != nil:
+		if s.Volume != nil && *s.Volume == *ev.Volume {
+			return false
+		}
+		v := *ev.Volume
+		s.Volume = &v
+	case ev.Type == EventAutoplayModeChanged:
+		if s.Autoplay == ev.Autoplay {
+			return false
+		}
+		s.Autoplay = ev.Autoplay
+	default:
+		return false
+	}
+	return true
+}
+
+// GetStatus sets up a new bind session and asks the tv app what it's playing
+// and its volume. Autoplay is set only if the tv app sends it when the
+// session is set up.
+func (r *Remote) GetStatus() (*Status, error) {
+	return r.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is like GetStatus(), but accepts a context.Context.
+func (r *Remote) GetStatusContext(ctx context.Context) (*Status, error) {
+	msgs, err := r.getSessionIds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getSessionIds: %w", err)
+	}
+	st := &Status{}
+	for _, m := range msgs {
+		st.Update(newEvent(m))
+	}
+	for _, name := range []string{"getNowPlaying", "getVolume"} {
+		if err := r.sendCommand(ctx, name, nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	nowPlaying := false // the Player from the session setup may be stale.
+	err = r.await(ctx, func(ev *Event) bool {
+		st.Update(ev)
+		nowPlaying = nowPlaying || ev.Type == EventNowPlaying
+		return nowPlaying && st.Volume != nil
+	})
+	if errors.Is(err, errNoResponse) && st.Player != nil {
+		return st, nil // better than nothing, e.g. the volume isn't sent by some tv apps.
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,64 @@
+// See license file for copyright and license details.
+
+package youtube
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusUpdate(t *testing.T) {
+	tests := []struct {
+		ev      *Event
+		changed bool
+		want    Status
+	}{
+		{
+			ev:      &Event{Type: EventNowPlaying, Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StateBuffering, Duration: 213 * time.Second}},
+			changed: true,
+			want:    Status{Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StateBuffering, Duration: 213 * time.Second}},
+		},
+		{
+			ev:      &Event{Type: EventStateChange, Player: &Player{CurrentIndex: -1, State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}},
+			changed: true,
+			want:    Status{Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}},
+		},
+		{
+			ev:      &Event{Type: EventStateChange, Player: &Player{CurrentIndex: -1, State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}},
+			changed: false,
+			want:    Status{Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}},
+		},
+		{
+			ev:      &Event{Type: EventVolumeChanged, Volume: &Volume{Level: 40}},
+			changed: true,
+			want:    Status{Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}, Volume: &Volume{Level: 40}},
+		},
+		{
+			ev:      &Event{Type: EventAutoplayModeChanged, Autoplay: "ENABLED"},
+			changed: true,
+			want:    Status{Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}, Volume: &Volume{Level: 40}, Autoplay: "ENABLED"},
+		},
+		{
+			ev:      &Event{Type: EventLoungeStatus},
+			changed: false,
+			want:    Status{Player: &Player{VideoId: "dQw4w9WgXcQ", ListId: "RQfoo", State: StatePlaying, CurrentTime: time.Second, Duration: 213 * time.Second}, Volume: &Volume{Level: 40}, Autoplay: "ENABLED"},
+		},
+		{
+			ev:      &Event{Type: EventNowPlaying, Player: &Player{CurrentIndex: -1, State: StateUnstarted}},
+			changed: true,
+			want:    Status{Player: &Player{CurrentIndex: -1, State: StateUnstarted}, Volume: &Volume{Level: 40}, Autoplay: "ENABLED"},
+		},
+	}
+
+	var st Status
+	for i, test := range tests {
+		changed := st.Update(test.ev)
+		if test.changed != changed {
+			t.Fatalf("tests[%d]: changed: want %t got %t", i, test.changed, changed)
+		}
+		if !reflect.DeepEqual(test.want, st) {
+			t.Fatalf("tests[%d]: want %+v got %+v", i, test.want, st)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package youtube
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"math/rand"
@@ -23,8 +24,17 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-func randDelay(min, max time.Duration) {
-	time.Sleep(min + time.Duration(rand.Int63n(int64(max-min))))
+// randDelay sleeps for a random duration between min and max, it returns
+// early with ctx's error if ctx is done.
+func randDelay(ctx context.Context, min, max time.Duration) error {
+	t := time.NewTimer(min + time.Duration(rand.Int63n(int64(max-min))))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // ExtractScreenId extracts the screen id of a YouTube TV app from the xml tag
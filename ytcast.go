@@ -4,18 +4,25 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/MarcoLucidi01/ytcast/dial"
@@ -30,10 +37,19 @@ const (
 	fallbackCacheDir = ".cache" // used if xdgCache is not set, stored in $HOME
 	cacheFileName    = progName + ".json"
 
-	launchTimeout       = 1 * time.Minute
-	launchCheckInterval = 3 * time.Second
+	// once a device matching -d is discovered, other matching devices
+	// have this long to show up before the discovery is stopped.
+	discoverSettle = 500 * time.Millisecond
+
+	launchTimeout          = 1 * time.Minute
+	launchCheckMinInterval = 250 * time.Millisecond // app state polling starts at this interval and doubles up to launchCheckMaxInterval.
+	launchCheckMaxInterval = 3 * time.Second
 
 	fallbackIdFormat = "0405.0000.2006010215" // poor man's UUID.
+
+	// cached devices not seen for this long after their announcement
+	// expired are removed from the cache (unless last used).
+	expiredPruneAge = 30 * 24 * time.Hour
 )
 
 var (
@@ -49,15 +65,32 @@ var (
 	errNoVideo         = errors.New("no video to play")
 	errUnknownAppState = errors.New("unknown app state")
 	errInvalidCode     = errors.New("invalid pairing code")
+	errNoDial          = errors.New("device was manually paired, DIAL not available")
+	errNotInstalled    = errors.New("app is not installed")
+	errNoApp           = errors.New("no app specified")
+	errNoAppFound      = errors.New("no known app found")
+	errNoRemote        = errors.New("not connected via YouTube Lounge yet, cast something first")
+	errNoSeekTime      = errors.New("no seek time specified")
+	errBadSeekTime     = errors.New("invalid seek time")
+	errBadVolume       = errors.New("invalid volume")
+	errBadQueueCmd     = errors.New("invalid queue command")
+	errLateCommand     = errors.New("command given after flags")
+	errSecureOnNoDev   = errors.New("-secureon needs a device selected with -d or -p")
 
 	flagAdd          = flag.Bool("a", false, "add video(s) to queue, don't change what's currently playing")
+	flagCallback     = flag.Bool("callback", false, "let the YouTube app post its screenId back to ytcast at launch, saving some polling (needs inbound connections allowed by the firewall)")
 	flagClearCache   = flag.Bool("c", false, "clear cache")
-	flagDevName      = flag.String("d", "", "select device by substring of name, hostname (ip) or unique service name")
+	flagFollow       = flag.Bool("follow", false, "with status, keep printing the status as JSON lines each time it changes, until interrupted")
+	flagDevName      = flag.String("d", "", "select device by substring of name, hostname (ip), unique service name, model or serial number")
 	flagNetInterface = flag.String("i", "", "specify network interface (or ip or hostname) to use for network operations")
+	flagHosts        = flag.String("hosts", "", "comma separated list of hosts (host[:port] or description url) to search with unicast, e.g. on routed networks")
+	flagInstall      = flag.Bool("install", false, "install the YouTube app if it's not installed on the device (if supported)")
 	flagLastUsed     = flag.Bool("p", false, "select last used device")
 	flagList         = flag.Bool("l", false, "list cached devices")
 	flagPairCode     = flag.String("pair", "", "manual pair using TV code, skip device discovery")
+	flagSecureOn     = flag.String("secureon", "", "set the SecureOn password (xx:xx:xx:xx:xx:xx or a.b.c.d) to Wake-on-LAN the selected device, stored in the cache (empty removes it)")
 	flagSearch       = flag.Bool("s", false, "search (discover) devices on the network and update cache")
+	flagScan         = flag.Bool("scan", false, "also scan the local subnet for devices (slow, for networks where multicast is filtered; subnets larger than /22 are reduced to the local /24), implies -s")
 	flagTimeout      = flag.Duration("t", dial.MSearchMinTimeout, fmt.Sprintf("search timeout (max %s)", dial.MSearchMaxTimeout))
 	flagVerbose      = flag.Bool("verbose", false, "enable verbose logging")
 	flagVersion      = flag.Bool("v", false, "print program version")
@@ -69,19 +102,65 @@ var (
 type cast struct {
 	Device   *dial.Device
 	Remote   *youtube.Remote
-	LastUsed bool // true if Device is the last successfully used Device.
-	cached   bool // true if Device was fetched from the cache and not just discovered/updated.
+	LastUsed bool   // true if Device is the last successfully used Device.
+	Offline  bool   // true if Device announced it was leaving the network (ssdp:byebye).
+	SecureOn string `json:",omitempty"` // SecureOn password to Wake-on-LAN Device (set with -secureon).
+	Scanned  bool   `json:",omitempty"` // true if Device was found by scanning the subnet (-scan) rather than with SSDP.
+	cached   bool   // true if Device was fetched from the cache and not just discovered/updated.
+}
+
+// command is a ytcast command which operates on the selected device (or on all
+// cached devices, if runAll is set) instead of casting videos to it. args are
+// the non-flag command-line arguments.
+type command struct {
+	usage  string // arguments synopsis.
+	descr  string
+	run    func(ctx context.Context, selected *cast, args []string) error
+	runAll func(ctx context.Context, cache map[string]*cast, localAddr string, args []string) error
+}
+
+var commands = map[string]*command{
+	"apps":          {descr: "list well-known DIAL apps available on the selected device", run: listApps},
+	"launch":        {usage: "App [payload]", descr: "launch any DIAL app on the selected device", run: launchApp},
+	"next":          {descr: "play the next video in the queue of the selected device", run: remoteCommand("Next", (*youtube.Remote).NextContext)},
+	"pause":         {descr: "pause the video playing on the selected device", run: remoteCommand("Pause", (*youtube.Remote).PauseContext)},
+	"previous":      {descr: "play the previous video in the queue of the selected device", run: remoteCommand("Previous", (*youtube.Remote).PreviousContext)},
+	"queue":         {usage: "[remove video|clear]", descr: "print or modify the queue of the selected device", run: manageQueue},
+	"resume":        {descr: "resume the video paused on the selected device", run: remoteCommand("Resume", (*youtube.Remote).ResumeContext)},
+	"seek":          {usage: "time", descr: "seek the video playing on the selected device to time (e.g. 1m30s or 90)", run: seekVideo},
+	"status":        {descr: "print what the selected device is playing (with -follow, print changes as JSON lines)", run: showStatus},
+	"stop":          {descr: "close the YouTube app on the selected device", run: stopYouTubeApp},
+	"volume":        {usage: "[level|+n|-n|mute|unmute]", descr: "print or change the volume of the selected device: level from 0 to 100 or relative (use -- before negative values)", run: changeVolume},
+	"wake":          {descr: "wake up the selected device with Wake-on-LAN, without casting anything", run: wakeDevice},
+	"watch-devices": {descr: "watch cached devices going online or offline (or changing address), printing events as JSON lines", runAll: watchDevices},
 }
 
 func main() {
 	flag.StringVar(flagDevName, "n", "", "deprecated, same as -d")
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-a|-c|-d|-i|-l|-p|-s|-t|-v|-pair|-verbose] [video...]\n\n", progName)
-		fmt.Fprintf(flag.CommandLine.Output(), "cast YouTube videos to your smart TV.\n\n")
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: %s [-a|-c|-d|-i|-l|-p|-s|-t|-v|-callback|-hosts|-install|-pair|-scan|-secureon|-verbose] [video...]\n", progName)
+		fmt.Fprintf(out, "       %s command [-c|-d|-i|-p|-s|-t|-follow|-hosts|-scan|-secureon|-verbose] [arg...]\n\n", progName)
+		fmt.Fprintf(out, "cast YouTube videos to your smart TV.\n\n")
 		flag.PrintDefaults()
-		fmt.Fprintf(flag.CommandLine.Output(), "\n%s %s\n%s\n", progName, progVersion, progRepo)
+		fmt.Fprintf(out, "\ncommands:\n")
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			synopsis := strings.TrimSpace(name + " " + commands[name].usage)
+			fmt.Fprintf(out, "  %s\n    \t%s\n", synopsis, commands[name].descr)
+		}
+		fmt.Fprintf(out, "\n%s %s\n%s\n", progName, progVersion, progRepo)
+	}
+	args := os.Args[1:]
+	var cmd *command
+	if len(args) > 0 && commands[args[0]] != nil {
+		cmd, args = commands[args[0]], args[1:]
 	}
-	flag.Parse()
+	flag.CommandLine.Parse(args) // exits on error.
 
 	if *flagVersion {
 		fmt.Printf("%s %s\n", progName, progVersion)
@@ -93,15 +172,58 @@ func main() {
 	}
 	log.Printf("%s %s\n", progName, progVersion)
 
-	if err := run(); err != nil {
+	// on SIGINT or SIGTERM, ctx is canceled so that network operations stop
+	// and run() returns normally, saving the cache. a second signal kills
+	// the program as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	defer stop()
+
+	if err := run(ctx, cmd); err != nil {
 		log.Println(err)
 		fmt.Fprintf(os.Stderr, "%s: %s\n", progName, err)
+		if a := advice(err); a != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", progName, a)
+		}
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	cacheFilePath := filepath.Join(mkCacheDir(), cacheFileName)
+// adviceErrors are errors that deserve some advice on what to do about them.
+var adviceErrors = []struct {
+	err    error
+	advice string
+}{
+	{errNotInstalled, "YouTube is not available on the device: install it from the device's app store (or try -install, if supported)"},
+	{dial.ErrAppNotFound, "the app is not available on the device: install it from the device's app store"},
+	{dial.ErrOriginRejected, "the device refused the request: look for a setting like \"allow casting\", \"mobile TV on\" or \"remote apps\" in its network settings"},
+	{dial.ErrPayloadTooLarge, "the device refused the launch payload as too large: try with a shorter payload"},
+	{dial.ErrNotImplemented, "the device doesn't support this operation"},
+	{dial.ErrAppUnavailable, "the device can't launch the app right now (busy or still booting?): try again in a few seconds"},
+	{errLateCommand, "commands must come before flags, e.g. " + progName + " pause -p"},
+}
+
+// advice returns some advice on what to do about err, or an empty string.
+func advice(err error) string {
+	for _, a := range adviceErrors {
+		if errors.Is(err, a.err) {
+			return a.advice
+		}
+	}
+	return ""
+}
+
+// run runs cmd on the selected device, if cmd is nil it casts videos to it.
+func run(ctx context.Context, cmd *command) error {
+	if cmd == nil && flag.NArg() > 0 && commands[flag.Arg(0)] != nil {
+		// e.g. ytcast -p pause, which would cast a video named pause.
+		return fmt.Errorf("%q: %w", flag.Arg(0), errLateCommand)
+	}
+	if isFlagSet("secureon") && ((*flagDevName == "" && !*flagLastUsed) || *flagPairCode != "" || (cmd != nil && cmd.runAll != nil)) {
+		return errSecureOnNoDev // it would be silently ignored.
+	}
+	cacheDir := mkCacheDir()
+	cacheFilePath := filepath.Join(cacheDir, cacheFileName)
 	cache := make(map[string]*cast)
 	if !*flagClearCache {
 		cache = loadCache(cacheFilePath)
@@ -120,18 +242,48 @@ func run() error {
 			localAddr = *flagNetInterface // -i is not a valid interface, maybe it's an ip or hostname
 		}
 		log.Printf("using local address %s for network operations", localAddr)
-		localAddr += ":0" // use a random port
+		localAddr = net.JoinHostPort(localAddr, "0") // use a random port
+	}
+
+	// while discovering or casting, passively listen for cached devices
+	// joining or leaving the network, so the cache can be updated without
+	// a full search.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var notifyCh chan *dial.Notification
+	listening := false
+	listen := func() {
+		if !listening {
+			notifyCh, listening = listenDevices(ctx, cache, localAddr), true
+		}
 	}
 
+	// icons are downloaded in the background while we cast, and saved
+	// with the cache.
+	icons := &iconCache{dir: cacheDir, paths: make(map[string]string)}
+	defer func() {
+		// runs before cancel and saveCache.
+		applyNotifications(ctx, cache, notifyCh, icons)
+		icons.apply(cache)
+	}()
+
 	if *flagPairCode != "" {
-		return manualPair(cache, localAddr, *flagPairCode)
+		return manualPair(ctx, cache, localAddr, *flagPairCode)
 	}
-	if len(cache) == 0 || *flagSearch {
-		if err := discoverDevices(cache, localAddr, *flagTimeout); err != nil {
+	// with -d, an empty cache is handled below with a targeted discovery.
+	if (len(cache) == 0 && *flagDevName == "") || *flagSearch || *flagScan {
+		listen()
+		if err := discoverDevices(ctx, cache, localAddr, *flagTimeout, icons, nil); err != nil {
 			return err
 		}
 	}
 
+	applyNotifications(ctx, cache, notifyCh, icons)
+
+	if cmd != nil && cmd.runAll != nil {
+		return cmd.runAll(ctx, cache, localAddr, flag.Args())
+	}
+
 	var selected *cast
 	switch {
 	case *flagDevName != "":
@@ -141,7 +293,11 @@ func run() error {
 		if !errors.Is(err, errNoDevMatch) {
 			return err
 		}
-		if err = discoverDevices(cache, localAddr, *flagTimeout); err != nil {
+		// no need to wait for the whole timeout, stop as soon as
+		// the device shows up.
+		match := func(c *cast) bool { return c.matches(*flagDevName) }
+		listen()
+		if err = discoverDevices(ctx, cache, localAddr, *flagTimeout, icons, match); err != nil {
 			return err
 		}
 		if len(cache) == 0 {
@@ -161,7 +317,7 @@ func run() error {
 		// discoverDevices() to give a chance to rediscover in -d case.
 		return errNoDevFound
 
-	case *flagList, *flagSearch:
+	case *flagList, *flagSearch, *flagScan:
 		listDevices(cache)
 		return nil
 
@@ -170,16 +326,6 @@ func run() error {
 		return errNoDevSelected
 	}
 
-	videos := flag.Args()
-	if len(videos) == 0 || (len(videos) == 1 && videos[0] == "-") {
-		if videos, err = readVideosFromStdin(); err != nil {
-			return err
-		}
-		if len(videos) == 0 {
-			return errNoVideo
-		}
-	}
-
 	// Device and (or) Remote could come from the cache, we need to make sure
 	// they use localAddr for network operations
 	if selected.Device != nil {
@@ -193,28 +339,100 @@ func run() error {
 		}
 	}
 
+	if isFlagSet("secureon") {
+		selected.SecureOn = *flagSecureOn
+	}
+
+	if cmd != nil {
+		return cmd.run(ctx, selected, flag.Args())
+	}
+
+	videos := flag.Args()
+	if len(videos) == 0 || (len(videos) == 1 && videos[0] == "-") {
+		if videos, err = readVideosFromStdin(ctx); err != nil {
+			return err
+		}
+		if len(videos) == 0 {
+			return errNoVideo
+		}
+	}
+
+	// a device not seen for a while may have rebooted and changed address,
+	// if it doesn't answer it's searched again (see castVideos()).
+	rediscover := func() error {
+		match := func(c *cast) bool { return c == selected }
+		if err := discoverDevices(ctx, cache, localAddr, *flagTimeout, icons, match); err != nil {
+			return err
+		}
+		return selected.Device.SetLocalAddr(localAddr)
+	}
+	listen()
+	return castVideos(ctx, cache, selected, localAddr, videos, rediscover)
+}
+
+// castVideos casts videos to the selected device (to be exact, it adds them to
+// the queue if -a is set). Independent steps overlap: the LoungeToken of the
+// cached Remote is refreshed while the device is woken up and the YouTube app
+// is launched, since the screenId rarely changes. If the
+// device doesn't answer and its announcement expired, it's searched again with
+// rediscover in case it changed address, unless it can be woken up
+// (TryWakeup() searches it too).
+func castVideos(ctx context.Context, cache map[string]*cast, selected *cast, localAddr string, videos []string, rediscover func() error) error {
+	tm := &timings{start: time.Now()}
+	defer tm.phase("cast")()
+	prepared := prepareRemote(ctx, selected.Remote, tm)
+
 	screenId := ""
 	if selected.wasManuallyPaired() {
 		// try to reuse the screenId since we can't know if it changed.
 		screenId = selected.Remote.ScreenId
 	} else {
-		if !selected.Device.Ping() {
+		done := tm.phase("ping")
+		awake := selected.Device.PingContext(ctx)
+		done()
+		if !awake && selected.stale() && selected.Device.Wakeup.Mac == "" {
+			log.Printf("%q not seen since %s, searching it again", selected.name(), selected.Device.LastSeen.Format(time.DateTime))
+			done := tm.phase("search")
+			err := rediscover()
+			done()
+			if err != nil {
+				return err
+			}
+			awake = selected.Device.PingContext(ctx)
+		}
+		if !awake {
 			log.Printf("%q is not awake, trying waking it up...", selected.name())
-			if err := selected.Device.TryWakeup(); err != nil {
+			done := tm.phase("wakeup")
+			err := selected.Device.TryWakeupWithOptionsContext(ctx, selected.wakeOptions())
+			done()
+			if err != nil {
 				return fmt.Errorf("%q: TryWakeup: %w", selected.name(), err)
 			}
 		}
-		if screenId, err = launchYouTubeApp(selected.Device); err != nil {
+		selected.Device.LastSeen = time.Now()
+		done = tm.phase("launch")
+		var err error
+		screenId, err = launchYouTubeApp(ctx, selected.Device, localAddr, *flagInstall, *flagCallback)
+		done()
+		if err != nil {
 			return err
 		}
 	}
 	for _, entry := range cache {
 		entry.LastUsed = entry == selected
 	}
+	selected.Offline = false
 
-	if needsToConnect(selected.Remote, screenId) {
+	if remote := <-prepared; remote != nil && remote.ScreenId == screenId {
+		selected.Remote = remote
+	} else {
+		if remote != nil {
+			log.Println("screenId changed")
+		}
 		log.Printf("connecting to %q via YouTube Lounge", selected.name())
-		remote, err := youtube.Connect(localAddr, screenId, getConnectName())
+		done := tm.phase("connect")
+		remote, err := youtube.ConnectContext(ctx, localAddr, screenId, getConnectName())
+		done()
 		if err != nil {
 			return fmt.Errorf("Connect: %w", err)
 		}
@@ -228,18 +446,63 @@ func run() error {
 	}
 	if *flagAdd {
 		log.Printf("requesting YouTube Lounge to add %v to %q's playing queue", videos, selected.name())
-		if err := selected.Remote.Add(videos); err != nil {
+		defer tm.phase("add")()
+		if err := selected.Remote.AddContext(ctx, videos); err != nil {
 			return fmt.Errorf("Add: %w", err)
 		}
 		return nil
 	}
 	log.Printf("requesting YouTube Lounge to play %v on %q", videos, selected.name())
-	if err := selected.Remote.Play(videos); err != nil {
+	defer tm.phase("play")()
+	if err := selected.Remote.PlayContext(ctx, videos); err != nil {
 		return fmt.Errorf("Play: %w", err)
 	}
 	return nil
 }
 
+// prepareRemote refreshes the LoungeToken of a copy of remote (if expired) in
+// the background. The prepared copy is sent to the returned channel, nil if
+// remote is nil or if it can't be prepared. The bind session is left to Play()
+// and Add(): it's set up once the app is running with the same screenId.
+func prepareRemote(ctx context.Context, remote *youtube.Remote, tm *timings) chan *youtube.Remote {
+	ch := make(chan *youtube.Remote, 1)
+	if remote == nil {
+		ch <- nil
+		return ch
+	}
+	r := *remote // the original is left untouched for the cache if anything fails.
+	go func() {
+		defer tm.phase("prepare")()
+		if r.Expired() {
+			log.Println("LoungeToken expired, trying refreshing it")
+			if err := r.RefreshTokenContext(ctx); err != nil {
+				log.Printf("RefreshToken: %s", err)
+				ch <- nil
+				return
+			}
+		}
+		ch <- &r
+	}()
+	return ch
+}
+
+// timings logs how long the phases of an operation take, relative to start.
+// Phases may overlap.
+type timings struct {
+	start time.Time
+}
+
+// phase starts timing the phase name and returns the function to call when
+// the phase is over.
+func (t *timings) phase(name string) func() {
+	start := time.Now()
+	return func() {
+		end := time.Now()
+		log.Printf("timing: %-8s %8s (+%s -> +%s)", name, end.Sub(start).Round(time.Millisecond),
+			start.Sub(t.start).Round(time.Millisecond), end.Sub(t.start).Round(time.Millisecond))
+	}
+}
+
 func mkCacheDir() string {
 	cacheDir := os.Getenv(xdgCache)
 	if cacheDir == "" {
@@ -274,6 +537,10 @@ func loadCache(fpath string) map[string]*cast {
 	}
 	for _, entry := range cacheValues {
 		entry.cached = true
+		if entry.longExpired() {
+			log.Printf("%q not seen since %s, removing it from the cache", entry.name(), entry.Device.LastSeen.Format(time.DateTime))
+			continue
+		}
 		cache[entry.uuid()] = entry
 	}
 	return cache
@@ -304,20 +571,33 @@ func addrFromInterface(name string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("%w: Addrs: %w", errNoAddr, err)
 	}
+	// prefer IPv4 since most devices don't speak IPv6 yet, but fall back to
+	// the first IPv6 address (with zone if it's link-local).
+	var v6 string
 	for _, addr := range addrs {
-		if a, ok := addr.(*net.IPNet); ok {
+		a, ok := addr.(*net.IPNet)
+		switch {
+		case !ok:
+		case a.IP.To4() != nil:
 			return a.IP.String(), nil
+		case v6 == "" && a.IP.IsLinkLocalUnicast():
+			v6 = a.IP.String() + "%" + iface.Name
+		case v6 == "":
+			v6 = a.IP.String()
 		}
 	}
+	if v6 != "" {
+		return v6, nil
+	}
 	return "", errNoAddr
 }
 
-func manualPair(cache map[string]*cast, localAddr, code string) error {
+func manualPair(ctx context.Context, cache map[string]*cast, localAddr, code string) error {
 	if code = strings.TrimSpace(code); code == "" {
 		return errInvalidCode
 	}
 	log.Println("connecting to device via YouTube Lounge and pairing code")
-	remote, err := youtube.ConnectWithCode(localAddr, code, getConnectName())
+	remote, err := youtube.ConnectWithCodeContext(ctx, localAddr, code, getConnectName())
 	if err != nil {
 		return fmt.Errorf("ConnectWithCode: %w", err)
 	}
@@ -337,30 +617,269 @@ func manualPair(cache map[string]*cast, localAddr, code string) error {
 	return nil
 }
 
-func discoverDevices(cache map[string]*cast, localAddr string, timeout time.Duration) error {
-	devCh, err := dial.Discover(nil, localAddr, timeout)
+// discoverDevices discovers devices on the network and updates the cache with
+// them. Besides multicast, the hosts given with -hosts and those of cached
+// devices discovered at a specific host are searched with unicast, and the
+// local subnet is scanned if -scan is set. Devices icons are downloaded in
+// the background with icons. If match is not nil, the discovery stops as soon
+// as a device matches (after discoverSettle, to catch other matching devices)
+// or as soon as a second one matches.
+func discoverDevices(ctx context.Context, cache map[string]*cast, localAddr string, timeout time.Duration, icons *iconCache, match func(*cast) bool) error {
+	discoverCtx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the discovery if it ends early.
+
+	// cached devices which didn't reboot nor change their description
+	// (see CONFIGID.UPNP.ORG) don't need to be fetched again.
+	var opts dial.DiscoverOptions
+	for _, entry := range cache {
+		if !entry.wasManuallyPaired() {
+			opts.Known = append(opts.Known, entry.Device)
+		}
+	}
+	devCh, err := dial.DiscoverWithOptionsContext(discoverCtx, localAddr, timeout, opts)
+	if hosts := searchHosts(cache); len(hosts) > 0 {
+		hostsCh, hostsErr := dial.DiscoverHostsContext(discoverCtx, localAddr, hosts, timeout)
+		switch {
+		case hostsErr != nil && err != nil:
+			err = errors.Join(err, hostsErr)
+		case hostsErr != nil:
+			log.Printf("DiscoverHosts: %s", hostsErr)
+		case err != nil:
+			log.Printf("Discover: %s", err)
+			devCh, err = hostsCh, nil
+		default:
+			devCh = mergeDevices(discoverCtx, devCh, hostsCh)
+		}
+	}
+	var scanCh chan *dial.Device
+	if *flagScan {
+		var scanErr error
+		if scanCh, scanErr = dial.Scan(discoverCtx, localAddr, dial.ScanOptions{}); scanErr != nil {
+			if err != nil {
+				return fmt.Errorf("Discover: %w", errors.Join(err, scanErr))
+			}
+			log.Printf("Scan: %s", scanErr)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("Discover: %w", err)
+		if scanCh == nil {
+			return fmt.Errorf("Discover: %w", err)
+		}
+		log.Printf("Discover: %s", err)
+		devCh = make(chan *dial.Device)
+		close(devCh)
 	}
-	for dev := range devCh {
+
+	update := func(dev *dial.Device, scanned bool) *cast {
+		icons.fetch(ctx, dev) // not stopped with the discovery.
 		if entry, ok := cache[dev.UniqueServiceName]; ok {
-			entry.Device = dev
+			// a device reached with multicast is no longer
+			// considered scanned, one reached at its (scanned)
+			// description url still is.
+			entry.Scanned = scanned || (entry.Scanned && dev.SearchHost != "")
+			// scanned devices and devices reached at their
+			// LOCATION (-hosts) lack the WAKEUP header values,
+			// keep the ones already known (and the host for the
+			// next unicast search).
+			entry.refresh(dev)
+			entry.Offline = false
 			entry.cached = false
-		} else {
-			cache[dev.UniqueServiceName] = &cast{Device: dev}
+			return entry
+		}
+		entry := &cast{Device: dev, Scanned: scanned}
+		cache[dev.UniqueServiceName] = entry
+		return entry
+	}
+
+	// consume updates the cache with the devices received from ch and
+	// returns true if the discovery must stop early because of match.
+	var matched []*cast
+	consume := func(ch chan *dial.Device, scanned bool) bool {
+		var settle <-chan time.Time // nil until a device matches, blocks forever.
+		for {
+			select {
+			case dev, ok := <-ch:
+				if !ok {
+					return false
+				}
+				if entry, ok := cache[dev.UniqueServiceName]; scanned && ok && !entry.cached {
+					// scanned devices only fill the gaps, SSDP
+					// responses carry more information (e.g.
+					// the WAKEUP header).
+					continue
+				}
+				entry := update(dev, scanned)
+				if match == nil || !match(entry) || slices.Contains(matched, entry) {
+					continue
+				}
+				if matched = append(matched, entry); len(matched) > 1 {
+					log.Printf("%q matches too, stopping discovery", entry.name())
+					return true
+				}
+				log.Printf("%q matches, waiting %s for other matching devices", entry.name(), discoverSettle)
+				settle = time.After(discoverSettle)
+			case <-settle:
+				return true
+			}
+		}
+	}
+	if !consume(devCh, false) && scanCh != nil {
+		consume(scanCh, true)
+	}
+	return ctx.Err() // discovery may have been interrupted.
+}
+
+// searchHosts returns the hosts given with -hosts plus the ones cached devices
+// have been discovered at, without duplicates.
+func searchHosts(cache map[string]*cast) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(host string) {
+		if host = strings.TrimSpace(host); host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	for _, host := range strings.Split(*flagHosts, ",") {
+		add(host)
+	}
+	for _, c := range cache {
+		if c.Device != nil {
+			add(c.Device.SearchHost)
+		}
+	}
+	return hosts
+}
+
+// mergeDevices merges a and b into a single channel which is closed when both
+// are closed (or when ctx is done). The same device may be received from both.
+func mergeDevices(ctx context.Context, a, b chan *dial.Device) chan *dial.Device {
+	ch := make(chan *dial.Device)
+	var wg sync.WaitGroup
+	for _, c := range []chan *dial.Device{a, b} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dev := range c {
+				select {
+				case ch <- dev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// iconCache downloads the icons of devices in dir in the background (see
+// dial.Device.CacheIcons()) and remembers where they are saved, so that their
+// paths can be set in the cache once done, whatever Device it holds by then.
+type iconCache struct {
+	dir   string
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	paths map[string]string // local path by icon url.
+}
+
+// fetch downloads the icons of dev in the background, unless they're already
+// downloaded. dev is not modified.
+func (ic *iconCache) fetch(ctx context.Context, dev *dial.Device) {
+	if !slices.ContainsFunc(dev.Icons, func(icon dial.Icon) bool { return icon.Path == "" }) {
+		return
+	}
+	d := *dev
+	d.Icons = slices.Clone(dev.Icons)
+	ic.wg.Add(1)
+	go func() {
+		defer ic.wg.Done()
+		if err := d.CacheIconsContext(ctx, ic.dir); err != nil {
+			log.Printf("%q: CacheIcons: %s", d.FriendlyName, err)
+		}
+		ic.mu.Lock()
+		defer ic.mu.Unlock()
+		for _, icon := range d.Icons {
+			if icon.Path != "" {
+				ic.paths[icon.Url] = icon.Path
+			}
+		}
+	}()
+}
+
+// apply waits for the downloads to finish and sets the local paths of the
+// icons of the devices in cache.
+func (ic *iconCache) apply(cache map[string]*cast) {
+	ic.wg.Wait()
+	for _, entry := range cache {
+		if entry.wasManuallyPaired() {
+			continue
+		}
+		for i, icon := range entry.Device.Icons {
+			if path, ok := ic.paths[icon.Url]; ok {
+				entry.Device.Icons[i].Path = path
+			}
+		}
+	}
+}
+
+// listenDevices listens for cached devices announcing themselves until ctx is
+// done, see applyNotifications(). Their descriptions are not fetched again if
+// unchanged.
+func listenDevices(ctx context.Context, cache map[string]*cast, localAddr string) chan *dial.Notification {
+	var opts dial.ListenOptions
+	for _, entry := range cache {
+		if !entry.wasManuallyPaired() {
+			opts.Known = append(opts.Known, entry.Device)
+		}
+	}
+	notifyCh, err := dial.ListenWithOptions(ctx, localAddr, opts)
+	if err != nil {
+		log.Printf("Listen: %s", err)
+		return nil // receiving from a nil channel never succeeds.
+	}
+	return notifyCh
+}
+
+// applyNotifications updates the cached devices with the dial.Notifications
+// received so far, without blocking: devices that said ssdp:byebye are marked
+// offline, devices that said ssdp:alive (or ssdp:update) are marked online and
+// updated (their icons are downloaded with icons if their description
+// changed). Devices not in the cache are ignored, they're added by searches.
+func applyNotifications(ctx context.Context, cache map[string]*cast, notifyCh chan *dial.Notification, icons *iconCache) {
+	for {
+		select {
+		case n, ok := <-notifyCh:
+			if !ok {
+				return
+			}
+			entry, found := cache[n.UniqueServiceName]
+			switch {
+			case !found:
+			case n.Type == dial.NotifyByebye:
+				log.Printf("%q is offline", entry.name())
+				entry.Offline = true
+			default:
+				log.Printf("%q is online", entry.name())
+				entry.refresh(n.Device)
+				icons.fetch(ctx, n.Device)
+				entry.Offline = false
+				entry.Scanned = false
+				entry.cached = false
+			}
+		default:
+			return
 		}
 	}
-	return nil
 }
 
 func matchOneDevice(cache map[string]*cast, name string) (*cast, error) {
-	nameLow := strings.ToLower(strings.TrimSpace(name))
 	var matched []*cast
 	for _, entry := range cache {
-		matches := strings.Contains(strings.ToLower(entry.name()), nameLow) ||
-			strings.Contains(strings.ToLower(entry.hostname()), nameLow) ||
-			strings.Contains(strings.ToLower(entry.uuid()), nameLow)
-		if matches {
+		if entry.matches(name) {
 			matched = append(matched, entry)
 		}
 	}
@@ -410,10 +929,51 @@ func listDevices(cache map[string]*cast) {
 	}
 }
 
+// matches reports whether name is a substring (case insensitive) of the name,
+// hostname, unique service name, model or serial number of the device.
+func (c *cast) matches(name string) bool {
+	nameLow := strings.ToLower(strings.TrimSpace(name))
+	return strings.Contains(strings.ToLower(c.name()), nameLow) ||
+		strings.Contains(strings.ToLower(c.hostname()), nameLow) ||
+		strings.Contains(strings.ToLower(c.uuid()), nameLow) ||
+		strings.Contains(strings.ToLower(c.model()), nameLow) ||
+		strings.Contains(strings.ToLower(c.serialNumber()), nameLow)
+}
+
 func (c *cast) wasManuallyPaired() bool {
 	return c.Device == nil // implies c.Remote != nil
 }
 
+// stale reports whether the Device announcement expired (see
+// dial.Device.Expired()): it may be off or it may have rebooted (i.e. changed
+// BOOTID.UPNP.ORG) and changed address or ports since it was last seen.
+// Manually paired devices are never stale.
+func (c *cast) stale() bool {
+	return !c.wasManuallyPaired() && c.Device.Expired()
+}
+
+// refresh updates the Device with dev, the same Device discovered (or
+// announced) again, keeping what dev lacks (e.g. the Wakeup values, usually
+// missing from NOTIFY messages). See dial.Device.Refresh().
+func (c *cast) refresh(dev *dial.Device) {
+	if c.wasManuallyPaired() {
+		c.Device = dev
+		return
+	}
+	c.Device.Refresh(dev)
+}
+
+// longExpired reports whether the Device has not been seen for
+// expiredPruneAge after its announcement expired. The last used device is
+// kept anyway.
+func (c *cast) longExpired() bool {
+	return c.stale() && !c.LastUsed && time.Since(c.Device.LastSeen) > c.Device.MaxAge+expiredPruneAge
+}
+
+func (c *cast) wakeOptions() dial.WakeOptions {
+	return dial.WakeOptions{Password: c.SecureOn}
+}
+
 func (c *cast) uuid() string {
 	if c.wasManuallyPaired() {
 		return c.Remote.DeviceId
@@ -435,6 +995,21 @@ func (c *cast) hostname() string {
 	return c.Device.Hostname()
 }
 
+// model returns manufacturer and model name of the Device (if available).
+func (c *cast) model() string {
+	if c.wasManuallyPaired() {
+		return ""
+	}
+	return strings.TrimSpace(c.Device.Manufacturer + " " + c.Device.ModelName)
+}
+
+func (c *cast) serialNumber() string {
+	if c.wasManuallyPaired() {
+		return ""
+	}
+	return c.Device.SerialNumber
+}
+
 func (c *cast) String() string {
 	var info []string
 	if c.cached {
@@ -443,19 +1018,60 @@ func (c *cast) String() string {
 	if c.LastUsed {
 		info = append(info, "lastused")
 	}
-	return fmt.Sprintf("%.8s %-15s %-30q %s",
-		strings.TrimPrefix(c.uuid(), "uuid:"), c.hostname(), c.name(), strings.Join(info, " "))
+	if c.Scanned {
+		info = append(info, "scanned")
+	}
+	if c.Offline {
+		info = append(info, "offline")
+	} else if c.cached && c.stale() {
+		// its announcement expired, it may be off or have changed
+		// address.
+		info = append(info, "expired")
+	}
+	return fmt.Sprintf("%.8s %-15s %-30q %-25.25s %s",
+		strings.TrimPrefix(c.uuid(), "uuid:"), c.hostname(), c.name(), c.model(), strings.Join(info, " "))
 }
 
-func launchYouTubeApp(dev *dial.Device) (string, error) {
-	for start := time.Now(); time.Since(start) < launchTimeout; time.Sleep(launchCheckInterval) {
-		app, err := dev.GetAppInfo(youtube.DialAppName, youtube.Origin)
+// launchYouTubeApp launches the YouTube app on dev (if not already running)
+// and returns its screenId. If install is true and the app is not installed,
+// it triggers the installation first and waits for it to complete.
+func launchYouTubeApp(ctx context.Context, dev *dial.Device, localAddr string, install, callback bool) (string, error) {
+	opts := dial.LaunchOptions{FriendlyName: getLauncherName(), ClientDialVer: dial.ClientDialVer}
+	if callback {
+		// the app may POST its additionalData (screenId) back to us
+		// right after the launch, this saves some GetAppInfo polling.
+		// it's opt-in because the DIAL server appends its own
+		// additionalDataUrl too and we can't know which one the app
+		// uses: if it's ours and it isn't reachable (e.g. firewall),
+		// the screenId shows up only with the polling below.
+		cb, err := dial.NewCallbackServer(localAddr)
+		if err != nil {
+			log.Printf("NewCallbackServer: %s", err)
+		} else {
+			defer cb.Close()
+			opts.Callback = cb
+		}
+	}
+	var additionalData <-chan string // nil until launched, blocks forever.
+
+	installing := false
+	interval := launchCheckMinInterval
+	for start := time.Now(); time.Since(start) < launchTimeout; {
+		app, err := dev.GetAppInfoContext(ctx, youtube.DialAppName, youtube.Origin)
+		if errors.Is(err, dial.ErrAppNotFound) {
+			// not even installable.
+			return "", fmt.Errorf("%q: %q: %w: %w", dev.FriendlyName, youtube.DialAppName, errNotInstalled, err)
+		}
 		if err != nil {
 			return "", fmt.Errorf("%q: GetAppInfo: %q: %w", dev.FriendlyName, youtube.DialAppName, err)
 		}
 
 		log.Printf("%q is %s on %q", youtube.DialAppName, app.State, dev.FriendlyName)
-		switch app.State {
+		state := app.State
+		if app.InstallUrl() != "" {
+			state = "installable" // strip =<URL>
+		}
+		switch state {
 		case "running":
 			screenId, err := youtube.ExtractScreenId(app.Additional.Data)
 			if err != nil {
@@ -468,44 +1084,488 @@ func launchYouTubeApp(dev *dial.Device) (string, error) {
 
 		case "stopped", "hidden":
 			log.Printf("launching %q on %q", youtube.DialAppName, dev.FriendlyName)
-			if _, err := dev.Launch(youtube.DialAppName, youtube.Origin, ""); err != nil {
+			res, err := dev.LaunchWithOptionsContext(ctx, youtube.DialAppName, youtube.Origin, "", opts)
+			if err != nil {
 				return "", fmt.Errorf("%q: Launch: %q: %w", dev.FriendlyName, youtube.DialAppName, err)
 			}
+			additionalData = res.AdditionalData
+			interval = launchCheckMinInterval // the app may be up in a moment.
+
+		case "installable":
+			switch {
+			case installing:
+				log.Printf("waiting for %q installation on %q", youtube.DialAppName, dev.FriendlyName)
+			case !install:
+				return "", fmt.Errorf("%q: %q: %w (run with -install to install it)", dev.FriendlyName, youtube.DialAppName, errNotInstalled)
+			default:
+				log.Printf("installing %q on %q", youtube.DialAppName, dev.FriendlyName)
+				if err := dev.InstallContext(ctx, app.InstallUrl(), youtube.Origin); err != nil {
+					return "", fmt.Errorf("%q: Install: %q: %w", dev.FriendlyName, youtube.DialAppName, err)
+				}
+				installing = true
+			}
+			interval = launchCheckMaxInterval // installations take a while.
 
 		default:
 			return "", fmt.Errorf("%q: %q: %q: %w", dev.FriendlyName, youtube.DialAppName, app.State, errUnknownAppState)
 		}
+
+		select {
+		case data := <-additionalData:
+			log.Printf("%q posted additionalData", youtube.DialAppName)
+			if screenId, err := youtube.ExtractScreenId(data); err == nil && screenId != "" {
+				return screenId, nil
+			}
+			log.Println("screenId not available in posted additionalData")
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		interval = min(interval*2, launchCheckMaxInterval)
 	}
 	return "", fmt.Errorf("%q: %q: %w", dev.FriendlyName, youtube.DialAppName, errNoLaunch)
 }
 
-func readVideosFromStdin() ([]string, error) {
-	log.Println("reading videos from stdin")
-	scanner := bufio.NewScanner(os.Stdin)
-	var videos []string
-	for scanner.Scan() {
-		if v := strings.TrimSpace(scanner.Text()); v != "" {
-			videos = append(videos, v)
+// wakeDevice wakes up the selected device with Wake-on-LAN, if it's not already
+// awake.
+func wakeDevice(ctx context.Context, selected *cast, args []string) error {
+	if selected.wasManuallyPaired() {
+		return fmt.Errorf("%q: %w", selected.name(), errNoDial)
+	}
+	if selected.Device.PingContext(ctx) {
+		log.Printf("%q is already awake", selected.name())
+		selected.Device.LastSeen = time.Now()
+		return nil
+	}
+	log.Printf("waking up %q...", selected.name())
+	if err := selected.Device.TryWakeupWithOptionsContext(ctx, selected.wakeOptions()); err != nil {
+		return fmt.Errorf("%q: TryWakeup: %w", selected.name(), err)
+	}
+	selected.Device.LastSeen = time.Now()
+	selected.Offline = false
+	return nil
+}
+
+// deviceEvent is a dial.Event printed by watch-devices.
+type deviceEvent struct {
+	Time              time.Time `json:"time"`
+	Event             string    `json:"event"`
+	Name              string    `json:"name"`
+	Hostname          string    `json:"hostname"`
+	UniqueServiceName string    `json:"usn"`
+	ApplicationUrl    string    `json:"applicationUrl"`
+	PreviousAppUrl    string    `json:"previousApplicationUrl,omitempty"`
+}
+
+// watchDevices watches the presence of all cached devices, printing events as
+// JSON lines until interrupted, and updates the cache accordingly.
+func watchDevices(ctx context.Context, cache map[string]*cast, localAddr string, args []string) error {
+	var devs []*dial.Device
+	for _, entry := range cache {
+		if entry.wasManuallyPaired() {
+			continue
+		}
+		if err := entry.Device.SetLocalAddr(localAddr); err != nil {
+			return fmt.Errorf("%q: SetLocalAddr: %w", entry.name(), err)
 		}
+		devs = append(devs, entry.Device)
+	}
+	if len(devs) == 0 {
+		return errNoDevFound
 	}
-	return videos, scanner.Err()
+	enc := json.NewEncoder(os.Stdout)
+	for ev := range dial.NewMonitor(localAddr, devs...).Watch(ctx) {
+		if entry, ok := cache[ev.Device.UniqueServiceName]; ok {
+			entry.refresh(ev.Device)
+			entry.Offline = ev.Type == dial.EventOffline
+			if !entry.Offline {
+				entry.Device.LastSeen = ev.Time
+			}
+		}
+		c := &cast{Device: ev.Device}
+		err := enc.Encode(deviceEvent{
+			Time:              ev.Time,
+			Event:             ev.Type,
+			Name:              c.name(),
+			Hostname:          c.hostname(),
+			UniqueServiceName: ev.Device.UniqueServiceName,
+			ApplicationUrl:    ev.Device.ApplicationUrl,
+			PreviousAppUrl:    ev.PreviousAppUrl,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil // interrupted.
 }
 
-func needsToConnect(remote *youtube.Remote, screenId string) bool {
-	switch {
-	case remote == nil:
-		return true
-	case remote.ScreenId != screenId:
-		log.Println("screenId changed")
-		return true
-	case remote.Expired():
+func stopYouTubeApp(ctx context.Context, selected *cast, args []string) error {
+	if selected.wasManuallyPaired() {
+		return fmt.Errorf("%q: %w", selected.name(), errNoDial)
+	}
+	log.Printf("stopping %q on %q", youtube.DialAppName, selected.name())
+	err := selected.Device.StopContext(ctx, youtube.DialAppName, youtube.Origin)
+	if errors.Is(err, dial.ErrNotRunning) {
+		log.Printf("%q is not running on %q", youtube.DialAppName, selected.name())
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%q: Stop: %q: %w", selected.name(), youtube.DialAppName, err)
+	}
+	return nil
+}
+
+func listApps(ctx context.Context, selected *cast, args []string) error {
+	if selected.wasManuallyPaired() {
+		return fmt.Errorf("%q: %w", selected.name(), errNoDial)
+	}
+	apps := make([]*dial.AppInfo, len(dial.WellKnownApps))
+	var wg sync.WaitGroup
+	for i, name := range dial.WellKnownApps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app, err := selected.Device.GetAppInfoContext(ctx, name, "")
+			if err != nil {
+				log.Printf("%q: GetAppInfo: %q: %s", selected.name(), name, err)
+				return
+			}
+			app.Name = name // some devices don't return it.
+			apps[i] = app
+		}()
+	}
+	wg.Wait()
+	found := false
+	for _, app := range apps {
+		if app == nil {
+			continue
+		}
+		found = true
+		state := app.State
+		if app.InstallUrl() != "" {
+			state = "installable"
+		}
+		fmt.Printf("%-25s %-11s allowStop=%t\n", app.Name, state, app.Options.AllowStop)
+	}
+	if !found {
+		return fmt.Errorf("%q: %w", selected.name(), errNoAppFound)
+	}
+	return nil
+}
+
+func launchApp(ctx context.Context, selected *cast, args []string) error {
+	if selected.wasManuallyPaired() {
+		return fmt.Errorf("%q: %w", selected.name(), errNoDial)
+	}
+	if len(args) == 0 {
+		return errNoApp
+	}
+	appName, payload := args[0], strings.Join(args[1:], " ")
+	log.Printf("launching %q on %q", appName, selected.name())
+	opts := dial.LaunchOptions{FriendlyName: getLauncherName(), ClientDialVer: dial.ClientDialVer}
+	res, err := selected.Device.LaunchWithOptionsContext(ctx, appName, "", payload, opts)
+	if err != nil {
+		return fmt.Errorf("%q: Launch: %q: %w", selected.name(), appName, err)
+	}
+	if res.InstanceUrl != "" {
+		log.Printf("%q instance url %s", appName, res.InstanceUrl)
+	}
+	return nil
+}
+
+// connectedRemote returns the cached Remote of the selected device, refreshing
+// its LoungeToken if expired. Unlike casting, the YouTube app is not launched:
+// commands using it act on what's already on the screen.
+func connectedRemote(ctx context.Context, selected *cast) (*youtube.Remote, error) {
+	if selected.Remote == nil {
+		return nil, fmt.Errorf("%q: %w", selected.name(), errNoRemote)
+	}
+	if selected.Remote.Expired() {
 		log.Println("LoungeToken expired, trying refreshing it")
-		if err := remote.RefreshToken(); err != nil {
-			log.Printf("RefreshToken: %s", err)
-			return true
+		if err := selected.Remote.RefreshTokenContext(ctx); err != nil {
+			return nil, fmt.Errorf("RefreshToken: %w", err)
 		}
 	}
-	return false
+	return selected.Remote, nil
+}
+
+// remoteCommand returns the run function of a command which sends a Lounge
+// command to the selected device with send (named name in logs and errors).
+func remoteCommand(name string, send func(*youtube.Remote, context.Context) error) func(context.Context, *cast, []string) error {
+	return func(ctx context.Context, selected *cast, args []string) error {
+		remote, err := connectedRemote(ctx, selected)
+		if err != nil {
+			return err
+		}
+		log.Printf("requesting YouTube Lounge to %s on %q", strings.ToLower(name), selected.name())
+		if err := send(remote, ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+}
+
+func seekVideo(ctx context.Context, selected *cast, args []string) error {
+	if len(args) == 0 {
+		return errNoSeekTime
+	}
+	t, err := parseSeekTime(args[0])
+	if err != nil {
+		return err
+	}
+	seek := func(remote *youtube.Remote, ctx context.Context) error { return remote.SeekToContext(ctx, t) }
+	return remoteCommand("SeekTo", seek)(ctx, selected, args)
+}
+
+// parseSeekTime parses a position in a video, either a duration (e.g. 1m30s)
+// or a number of seconds.
+func parseSeekTime(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		// ParseFloat() accepts NaN and infinities too.
+		if math.IsNaN(secs) || secs < 0 || secs > math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("%q: %w", s, errBadSeekTime)
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	t, err := time.ParseDuration(s)
+	if err != nil || t < 0 {
+		return 0, fmt.Errorf("%q: %w", s, errBadSeekTime)
+	}
+	return t, nil
+}
+
+// changeVolume prints the volume of the selected device if args is empty,
+// otherwise it changes it according to args[0] (see parseVolume()) or mutes
+// or unmutes the device.
+func changeVolume(ctx context.Context, selected *cast, args []string) error {
+	remote, err := connectedRemote(ctx, selected)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		vol, err := remote.GetVolumeContext(ctx)
+		if err != nil {
+			return fmt.Errorf("GetVolume: %w", err)
+		}
+		muted := ""
+		if vol.Muted {
+			muted = " (muted)"
+		}
+		fmt.Printf("%d%s\n", vol.Level, muted)
+		return nil
+	}
+	switch args[0] {
+	case "mute":
+		return remoteCommand("Mute", (*youtube.Remote).MuteContext)(ctx, selected, args)
+	case "unmute":
+		return remoteCommand("Unmute", (*youtube.Remote).UnmuteContext)(ctx, selected, args)
+	}
+	level, relative, err := parseVolume(args[0])
+	if err != nil {
+		return err
+	}
+	if relative {
+		vol, err := remote.GetVolumeContext(ctx)
+		if err != nil {
+			return fmt.Errorf("GetVolume: %w", err)
+		}
+		level += vol.Level
+	}
+	log.Printf("requesting YouTube Lounge to set volume %d on %q", level, selected.name())
+	if err := remote.SetVolumeContext(ctx, level); err != nil {
+		return fmt.Errorf("SetVolume: %w", err)
+	}
+	return nil
+}
+
+// parseVolume parses a volume level from 0 to 100 or, if it starts with + or
+// -, a change relative to the current level.
+func parseVolume(s string) (int, bool, error) {
+	n, err := strconv.Atoi(s)
+	relative := strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-")
+	if err != nil || (!relative && n > 100) {
+		return 0, false, fmt.Errorf("%q: %w", s, errBadVolume)
+	}
+	return n, relative, nil
+}
+
+// statusLine is a youtube.Status printed by status -follow. Times are in
+// seconds.
+type statusLine struct {
+	Time        time.Time `json:"time"`
+	VideoId     string    `json:"videoId"`
+	ListId      string    `json:"listId,omitempty"`
+	State       string    `json:"state"`
+	CurrentTime float64   `json:"currentTime"`
+	Duration    float64   `json:"duration"`
+	Volume      *int      `json:"volume,omitempty"`
+	Muted       bool      `json:"muted"`
+	Autoplay    string    `json:"autoplay,omitempty"`
+}
+
+func newStatusLine(st *youtube.Status) statusLine {
+	line := statusLine{Time: time.Now(), State: youtube.StateUnstarted.String(), Autoplay: st.Autoplay}
+	if p := st.Player; p != nil {
+		line.VideoId, line.ListId, line.State = p.VideoId, p.ListId, p.State.String()
+		line.CurrentTime, line.Duration = p.CurrentTime.Seconds(), p.Duration.Seconds()
+	}
+	if v := st.Volume; v != nil {
+		line.Volume, line.Muted = &v.Level, v.Muted
+	}
+	return line
+}
+
+// showStatus prints what the selected device is playing. With -follow, it
+// prints the status as JSON lines each time it changes, until interrupted.
+func showStatus(ctx context.Context, selected *cast, args []string) error {
+	remote, err := connectedRemote(ctx, selected)
+	if err != nil {
+		return err
+	}
+	st, err := remote.GetStatusContext(ctx)
+	if err != nil {
+		return fmt.Errorf("GetStatus: %w", err)
+	}
+	if !*flagFollow {
+		printStatus(st)
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	if err := enc.Encode(newStatusLine(st)); err != nil {
+		return err
+	}
+	events, err := remote.Events(ctx)
+	if err != nil {
+		return fmt.Errorf("Events: %w", err)
+	}
+	for ev := range events {
+		if !st.Update(ev) {
+			continue
+		}
+		if err := enc.Encode(newStatusLine(st)); err != nil {
+			return err
+		}
+	}
+	return nil // interrupted.
+}
+
+func printStatus(st *youtube.Status) {
+	video, state, position := "-", youtube.StateUnstarted.String(), "-"
+	if p := st.Player; p != nil {
+		if p.VideoId != "" {
+			video = p.VideoId
+		}
+		state = p.State.String()
+		position = fmt.Sprintf("%s / %s", p.CurrentTime.Round(time.Second), p.Duration.Round(time.Second))
+	}
+	volume := "-"
+	if v := st.Volume; v != nil {
+		volume = strconv.Itoa(v.Level)
+		if v.Muted {
+			volume += " (muted)"
+		}
+	}
+	autoplay := "-"
+	if st.Autoplay != "" {
+		autoplay = st.Autoplay
+	}
+	fmt.Printf("%-9s %s\n", "video", video)
+	fmt.Printf("%-9s %s\n", "state", state)
+	fmt.Printf("%-9s %s\n", "time", position)
+	fmt.Printf("%-9s %s\n", "volume", volume)
+	fmt.Printf("%-9s %s\n", "autoplay", autoplay)
+}
+
+// manageQueue prints the queue of the selected device if args is empty,
+// otherwise it modifies it according to args and prints it as modified.
+func manageQueue(ctx context.Context, selected *cast, args []string) error {
+	remote, err := connectedRemote(ctx, selected)
+	if err != nil {
+		return err
+	}
+	var pl *youtube.Playlist
+	switch {
+	case len(args) == 0:
+		if pl, err = remote.GetPlaylistContext(ctx); err != nil {
+			return fmt.Errorf("GetPlaylist: %w", err)
+		}
+	case args[0] == "remove" && len(args) == 2:
+		log.Printf("requesting YouTube Lounge to remove %s from %q's queue", args[1], selected.name())
+		if pl, err = remote.RemoveVideoContext(ctx, args[1]); err != nil {
+			return fmt.Errorf("RemoveVideo: %w", err)
+		}
+	case args[0] == "clear" && len(args) == 1:
+		log.Printf("requesting YouTube Lounge to clear %q's queue", selected.name())
+		if pl, err = remote.ClearPlaylistContext(ctx); err != nil {
+			return fmt.Errorf("ClearPlaylist: %w", err)
+		}
+	default:
+		return fmt.Errorf("%q: %w", strings.Join(args, " "), errBadQueueCmd)
+	}
+	printQueue(pl)
+	return nil
+}
+
+// printQueue prints the videos in pl, one per line with its position, marking
+// the current one with >. If the videos are unknown, only the current one is
+// printed, with ? as position if that's unknown too.
+func printQueue(pl *youtube.Playlist) {
+	if len(pl.VideoIds) == 0 {
+		if pl.VideoId != "" {
+			pos := "  ?" // position unknown.
+			if pl.CurrentIndex >= 0 {
+				pos = fmt.Sprintf("%3d", pl.CurrentIndex+1)
+			}
+			fmt.Printf("> %s %s\n", pos, pl.VideoId)
+		}
+		log.Printf("videos in queue %s not reported by the tv app", pl.ListId)
+		return
+	}
+	for i, id := range pl.VideoIds {
+		mark := " "
+		if i == pl.CurrentIndex {
+			mark = ">"
+		}
+		fmt.Printf("%s %3d %s\n", mark, i+1, id)
+	}
+}
+
+// readVideosFromStdin reads videos from stdin, one per line, until EOF or until
+// ctx is done.
+func readVideosFromStdin(ctx context.Context) ([]string, error) {
+	log.Println("reading videos from stdin")
+	type result struct {
+		videos []string
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		var videos []string
+		for scanner.Scan() {
+			if v := strings.TrimSpace(scanner.Text()); v != "" {
+				videos = append(videos, v)
+			}
+		}
+		ch <- result{videos, scanner.Err()}
+	}()
+	select {
+	case res := <-ch:
+		return res.videos, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isFlagSet reports whether the flag name was passed on the command-line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func getConnectName() string {
@@ -521,3 +1581,12 @@ func getConnectName() string {
 	}
 	return fmt.Sprintf("%s@%s", u.Username, h)
 }
+
+func getLauncherName() string {
+	h, err := os.Hostname()
+	if err != nil {
+		log.Println(err)
+		return progName
+	}
+	return fmt.Sprintf("%s@%s", progName, h)
+}
@@ -0,0 +1,196 @@
+// See license file for copyright and license details.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MarcoLucidi01/ytcast/dial"
+	"github.com/MarcoLucidi01/ytcast/dial/dialtest"
+	"github.com/MarcoLucidi01/ytcast/youtube"
+)
+
+// fakeLounge is a fake YouTube Lounge API which records the commands sent
+// to the screen.
+type fakeLounge struct {
+	mu       sync.Mutex
+	tokens   int      // LoungeToken requests.
+	commands []string // e.g. setPlaylist videoId=dQw4w9WgXcQ.
+}
+
+func (l *fakeLounge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case r.URL.Path == "/api/lounge/pairing/get_lounge_token_batch":
+		l.tokens++
+		exp := time.Now().Add(time.Hour).UnixMilli()
+		fmt.Fprintf(w, `{"screens":[{"screenId":%q,"loungeToken":"token","expiration":%d}]}`, r.PostForm.Get("screen_ids"), exp)
+	case r.URL.Path == "/api/lounge/bc/bind" && r.URL.Query().Get("SID") == "":
+		data := "[[0,[\"c\",\"sid\",\"\",8]]\n,[1,[\"S\",\"gsession\"]]\n]\n"
+		fmt.Fprintf(w, "%d\n%s", len(data), data)
+	case r.URL.Path == "/api/lounge/bc/bind":
+		l.commands = append(l.commands, r.PostForm.Get("req0__sc")+" videoId="+r.PostForm.Get("req0_videoId"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// startLounge starts a fakeLounge and makes the HTTP clients which use the
+// default transport reach it in place of www.youtube.com.
+func startLounge(t *testing.T) *fakeLounge {
+	l := &fakeLounge{}
+	srv := httptest.NewTLSServer(l)
+	t.Cleanup(srv.Close)
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tr.TLSClientConfig.ServerName = "example.com" // the name in the certificate of srv.
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "www.youtube.com:443" {
+			addr = srv.Listener.Addr().String()
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	orig := http.DefaultTransport
+	http.DefaultTransport = tr
+	t.Cleanup(func() { http.DefaultTransport = orig })
+	return l
+}
+
+// runArgs runs ytcast with args as command-line arguments, flags are reset to
+// their defaults afterwards.
+func runArgs(t *testing.T, args ...string) error {
+	var cmd *command
+	if len(args) > 0 && commands[args[0]] != nil {
+		cmd, args = commands[args[0]], args[1:]
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return run(ctx, cmd)
+}
+
+func TestCast(t *testing.T) {
+	lounge := startLounge(t)
+	d := dialtest.NewDevice("Fake TV", &dialtest.App{
+		Name:           youtube.DialAppName,
+		AdditionalData: "<screenId>fake-screen</screenId>",
+		LaunchDelay:    300 * time.Millisecond,
+	})
+	if err := d.Start("127.0.0.1"); err != nil {
+		t.Skipf("Start: %s", err)
+	}
+	defer d.Close()
+	cacheDir := t.TempDir()
+	t.Setenv(xdgCache, cacheDir)
+
+	tests := []struct {
+		args     []string
+		tokens   int
+		commands []string
+	}{
+		{
+			// discovered, launched and connected.
+			args:     []string{"-hosts", d.SSDPAddr(), "-d", "fake tv", "dQw4w9WgXcQ"},
+			tokens:   1,
+			commands: []string{"setPlaylist videoId=dQw4w9WgXcQ"},
+		},
+		{
+			// already running, the cached Remote is reused.
+			args:     []string{"-p", "https://youtu.be/cdKop6aixVE"},
+			tokens:   1,
+			commands: []string{"setPlaylist videoId=dQw4w9WgXcQ", "setPlaylist videoId=cdKop6aixVE"},
+		},
+	}
+
+	for i, test := range tests {
+		if err := runArgs(t, test.args...); err != nil {
+			t.Fatalf("tests[%d]: unexpected error: %s", i, err)
+		}
+		if state := d.AppState(youtube.DialAppName); state != "running" {
+			t.Fatalf("tests[%d]: AppState: want %q got %q", i, "running", state)
+		}
+		lounge.mu.Lock()
+		tokens, commands := lounge.tokens, strings.Join(lounge.commands, "; ")
+		lounge.mu.Unlock()
+		if tokens != test.tokens {
+			t.Fatalf("tests[%d]: LoungeToken requests: want %d got %d", i, test.tokens, tokens)
+		}
+		if want := strings.Join(test.commands, "; "); commands != want {
+			t.Fatalf("tests[%d]: commands: want %q got %q", i, want, commands)
+		}
+	}
+
+	cache := loadCache(filepath.Join(cacheDir, progName, cacheFileName))
+	selected := findLastUsedDevice(cache)
+	if selected == nil || selected.Remote == nil {
+		t.Fatal("last used device not cached with its Remote")
+	}
+	if selected.Device.ApplicationUrl != d.ApplicationUrl() {
+		t.Fatalf("ApplicationUrl: want %q got %q", d.ApplicationUrl(), selected.Device.ApplicationUrl)
+	}
+	if selected.Remote.ScreenId != "fake-screen" {
+		t.Fatalf("ScreenId: want %q got %q", "fake-screen", selected.Remote.ScreenId)
+	}
+}
+
+func TestSecureOnNoDevice(t *testing.T) {
+	t.Setenv(xdgCache, t.TempDir())
+	tests := [][]string{
+		{"-secureon", "01:02:03:04:05:06"},
+		{"-l", "-secureon", "01:02:03:04:05:06"},
+		{"-pair", "123456789012", "-d", "tv", "-secureon", ""},
+		{"watch-devices", "-d", "tv", "-secureon", "01:02:03:04:05:06"},
+	}
+
+	for i, args := range tests {
+		if err := runArgs(t, args...); !errors.Is(err, errSecureOnNoDev) {
+			t.Fatalf("tests[%d]: want %v got %v", i, errSecureOnNoDev, err)
+		}
+	}
+}
+
+func TestAdvice(t *testing.T) {
+	tests := []struct {
+		err     error
+		install bool // whether -install is suggested.
+	}{
+		{err: fmt.Errorf("%q: %q: %w: %w", "tv", youtube.DialAppName, errNotInstalled, dial.ErrAppNotFound), install: true},
+		{err: fmt.Errorf("%q: %q: %w (run with -install to install it)", "tv", youtube.DialAppName, errNotInstalled), install: true},
+		{err: fmt.Errorf("%q: Launch: %q: %w", "tv", "Netflix", dial.ErrAppNotFound), install: false},
+	}
+
+	for i, test := range tests {
+		a := advice(test.err)
+		if a == "" {
+			t.Fatalf("tests[%d]: no advice for %q", i, test.err)
+		}
+		if install := strings.Contains(a, "-install"); install != test.install {
+			t.Fatalf("tests[%d]: -install suggested: want %t got %t (%q)", i, test.install, install, a)
+		}
+	}
+}